@@ -37,11 +37,26 @@ type App struct {
 	ctx      context.Context
 	logFile  *os.File
 	logToStd bool
+
+	sessionMu      sync.Mutex
+	sydneySessions map[int]*sydneySession // key: workspace id
+
+	poolMu           sync.Mutex
+	conversationPool *sydney.ConversationPool // nil if disabled
+
+	accountMu      sync.Mutex
+	accountPool    *sydney.AccountPool // nil if disabled
+	accountPoolKey string              // the settings accountPool has been created with
+
+	sydneyMu  sync.Mutex
+	sydneys   map[string]*sydney.Sydney // shared by all requests, by account, see createSydney
+	sydneyKey string                    // the settings sydneys have been created with
 }
 
 // NewApp creates a new App application struct
 func NewApp(settings *Settings) *App {
-	return &App{settings: settings}
+	return &App{settings: settings, sydneySessions: map[int]*sydneySession{},
+		sydneys: map[string]*sydney.Sydney{}}
 }
 
 // startup is called when the app starts. The context is saved
@@ -68,6 +83,12 @@ func (a *App) startup(ctx context.Context) {
 	}()
 }
 func (a *App) shutdown(ctx context.Context) {
+	a.poolMu.Lock()
+	if a.conversationPool != nil {
+		a.conversationPool.Close()
+	}
+	a.poolMu.Unlock()
+	util.DefaultHTTPClientFactory.Close()
 	if !a.logToStd {
 		a.logFile.Close()
 	}
@@ -117,7 +138,7 @@ func (a *App) UploadSydneyImage() (UploadSydneyImageResult, error) {
 	if file == "" {
 		return UploadSydneyImageResult{Canceled: true}, nil
 	}
-	sydneyIns, err := a.createSydney()
+	sydneyIns, account, err := a.createSydney()
 	if err != nil {
 		return UploadSydneyImageResult{}, err
 	}
@@ -129,7 +150,8 @@ func (a *App) UploadSydneyImage() (UploadSydneyImageResult, error) {
 	if err != nil {
 		return UploadSydneyImageResult{}, err
 	}
-	url, err := sydneyIns.UploadImage(jpgData)
+	url, err := sydneyIns.UploadImage(a.ctx, jpgData)
+	a.reportAccount(account, err)
 	if err != nil {
 		return UploadSydneyImageResult{}, err
 	}
@@ -244,14 +266,41 @@ func (a *App) FetchWebpage(url string) (FetchWebpageResult, error) {
 	}, nil
 }
 
+// GetLocationPresets returns the names of the locations Bing can be told the user is at.
+func (a *App) GetLocationPresets() []string {
+	return lo.Map(sydney.LocationPresets, func(location sydney.Location, _ int) string {
+		return location.Name
+	})
+}
+
 func (a *App) GetUser() (string, error) {
-	sydneyIns, err := a.createSydney()
+	sydneyIns, err := a.peekSydney()
 	if err != nil {
 		return "", err
 	}
 	return sydneyIns.GetUser()
 }
 
+// RunDiagnostics checks the proxy, the cookies, Bing and the OpenAI backends step by step.
+func (a *App) RunDiagnostics() (sydney.DiagnosticReport, error) {
+	sydneyIns, err := a.peekSydney()
+	if err != nil {
+		return sydney.DiagnosticReport{}, err
+	}
+	ctx, cancel := util.CreateTimeoutContext(2 * time.Minute)
+	defer cancel()
+	return sydneyIns.RunDiagnostics(ctx, sydney.DiagnosticsOptions{
+		OpenAIBackends: lo.Map(a.settings.config.OpenAIBackends,
+			func(backend OpenAIBackend, _ int) sydney.DiagnosticsOpenAIBackend {
+				return sydney.DiagnosticsOpenAIBackend{
+					Name:     backend.Name,
+					Endpoint: backend.OpenaiEndpoint,
+					Key:      backend.OpenaiKey,
+				}
+			}),
+	}), nil
+}
+
 type CheckUpdateResult struct {
 	NeedUpdate     bool   `json:"need_update"`
 	CurrentVersion string `json:"current_version"`
@@ -303,19 +352,23 @@ func (a *App) CheckUpdate() (CheckUpdateResult, error) {
 
 func (a *App) GenerateImage(generativeImage sydney.GenerativeImage) (sydney.GenerateImageResult, error) {
 	empty := sydney.GenerateImageResult{}
-	syd, err := a.createSydney()
+	syd, account, err := a.createSydney()
 	if err != nil {
 		return empty, err
 	}
-	return syd.GenerateImage(generativeImage)
+	result, err := syd.GenerateImage(a.ctx, generativeImage)
+	a.reportAccount(account, err)
+	return result, err
 }
 func (a *App) GenerateMusic(generativeMusic sydney.GenerativeMusic) (sydney.GenerateMusicResult, error) {
 	var empty sydney.GenerateMusicResult
-	syd, err := a.createSydney()
+	syd, account, err := a.createSydney()
 	if err != nil {
 		return empty, err
 	}
-	return syd.GenerateMusic(generativeMusic)
+	result, err := syd.GenerateMusic(a.ctx, generativeMusic)
+	a.reportAccount(account, err)
+	return result, err
 }
 func (a *App) SaveRemoteJPEGImage(url string) error {
 	if strings.Contains(url, "?") {
@@ -384,6 +437,61 @@ func (a *App) SaveRemoteFile(extWithoutDot, defaultFilenameWithoutExt, url strin
 	}
 	return os.WriteFile(filePath, resp.Bytes(), 0644)
 }
+
+// workspaceDir returns the directory where the files of the workspace are saved.
+func workspaceDir(workspace Workspace) string {
+	return util.WithPath(filepath.Join("workspaces", strconv.Itoa(workspace.ID)))
+}
+
+// SaveCodeArtifact saves the source and output of a code interpreter run, and downloads the
+// images and files it produced, into a new directory under the current workspace directory.
+// It returns the directory.
+func (a *App) SaveCodeArtifact(artifact sydney.CodeArtifact) (string, error) {
+	workspace, err := a.settings.config.GetCurrentWorkspace()
+	if err != nil {
+		return "", err
+	}
+	// one directory per run so that saving again doesn't overwrite the previous artifacts
+	dir := filepath.Join(workspaceDir(workspace), "artifacts", time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	ext := lo.Ternary(artifact.Language == "python", "py", artifact.Language)
+	if artifact.Source != "" {
+		if err := os.WriteFile(filepath.Join(dir, "code."+ext), []byte(artifact.Source), 0644); err != nil {
+			return "", err
+		}
+	}
+	if artifact.Stdout != "" {
+		if err := os.WriteFile(filepath.Join(dir, "stdout.txt"), []byte(artifact.Stdout), 0644); err != nil {
+			return "", err
+		}
+	}
+	_, client, err := util.MakeHTTPClient(a.settings.config.Proxy, 60*time.Second)
+	if err != nil {
+		return "", err
+	}
+	for i, fileURL := range append(artifact.Images, artifact.Files...) {
+		resp, err := client.R().Get(fileURL)
+		if err != nil {
+			return "", err
+		}
+		if !resp.IsSuccessState() {
+			return "", errors.New("cannot download " + fileURL + ": " + resp.Status)
+		}
+		name := filepath.Base(strings.Split(fileURL, "?")[0])
+		// prefix with the index so that files of the same name don't overwrite each other
+		name, err = filenamify.FilenamifyV2(strconv.Itoa(i+1) + "-" +
+			lo.Ternary(name == "." || name == "/", "file", name))
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), resp.Bytes(), 0644); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
 func (a *App) ExportWorkspace(id int) error {
 	workspace, ok := lo.Find(a.settings.config.Workspaces, func(item Workspace) bool {
 		return item.ID == id
@@ -3,8 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/life4/genesis/slices"
 	"github.com/samber/lo"
 	"github.com/sashabaranov/go-openai"
@@ -34,11 +34,40 @@ type AskOptions struct {
 }
 
 const (
-	ChatFinishResultErrTypeMessageRevoke   = "message_revoke"
-	ChatFinishResultErrTypeMessageFiltered = "message_filtered"
-	ChatFinishResultErrTypeOthers          = "others"
+	ChatFinishResultErrTypeMessageRevoke      = "message_revoke"
+	ChatFinishResultErrTypeMessageFiltered    = "message_filtered"
+	ChatFinishResultErrTypeCaptchaRequired    = "captcha_required"
+	ChatFinishResultErrTypeThrottled          = "throttled"
+	ChatFinishResultErrTypeUnauthorized       = "unauthorized"
+	ChatFinishResultErrTypeContextTooLong     = "context_too_long"
+	ChatFinishResultErrTypeConversationCreate = "conversation_create"
+	ChatFinishResultErrTypeTimeout            = "timeout"
+	ChatFinishResultErrTypeOthers             = "others"
 )
 
+// chatFinishResultErrType returns the ChatFinishResultErrType* of the error from Sydney.
+func chatFinishResultErrType(err error) string {
+	switch {
+	case errors.Is(err, sydney.ErrMessageRevoke):
+		return ChatFinishResultErrTypeMessageRevoke
+	case errors.Is(err, sydney.ErrMessageFiltered):
+		return ChatFinishResultErrTypeMessageFiltered
+	case errors.Is(err, sydney.ErrCaptchaRequired):
+		return ChatFinishResultErrTypeCaptchaRequired
+	case errors.Is(err, sydney.ErrThrottled):
+		return ChatFinishResultErrTypeThrottled
+	case errors.Is(err, sydney.ErrUnauthorized):
+		return ChatFinishResultErrTypeUnauthorized
+	case errors.Is(err, sydney.ErrContextTooLong):
+		return ChatFinishResultErrTypeContextTooLong
+	case errors.Is(err, sydney.ErrConversationCreate):
+		return ChatFinishResultErrTypeConversationCreate
+	case errors.As(err, new(*sydney.TimeoutError)):
+		return ChatFinishResultErrTypeTimeout
+	}
+	return ChatFinishResultErrTypeOthers
+}
+
 type ChatFinishResult struct {
 	Success bool   `json:"success"`
 	ErrType string `json:"err_type"`
@@ -54,6 +83,9 @@ const (
 	EventChatGenerateImage      = "chat_generate_image"
 	EventChatGenerateMusic      = "chat_generate_music"
 	EventChatResolvingCaptcha   = "chat_resolving_captcha"
+	EventChatReconnecting       = "chat_reconnecting"
+	EventChatThrottling         = "chat_throttling"
+	EventChatCodeArtifact       = "chat_code_artifact"
 )
 
 const (
@@ -63,29 +95,237 @@ const (
 func (a *App) Dummy1() ChatFinishResult {
 	return ChatFinishResult{}
 }
-func (a *App) createSydney() (*sydney.Sydney, error) {
-	currentWorkspace, err := a.settings.config.GetCurrentWorkspace()
+
+// createSydney returns the Sydney of the account picked from the account pool, or of cookies.json
+// if the pool is disabled, in which case the account is nil. The Sydney of each account is
+// shared by all requests and created again only if the cookies or the settings it depends on
+// have changed. The chat options of the current workspace are not part of it and are passed
+// per request, see workspaceChatOptions.
+func (a *App) createSydney() (*sydney.Sydney, *sydney.Account, error) {
+	return a.sydneyOfAccount((*sydney.AccountPool).Pick)
+}
+
+// peekSydney is like createSydney, but checks the account next in turn without taking it.
+func (a *App) peekSydney() (*sydney.Sydney, error) {
+	syd, _, err := a.sydneyOfAccount((*sydney.AccountPool).Peek)
+	return syd, err
+}
+
+func (a *App) sydneyOfAccount(pick func(*sydney.AccountPool) (*sydney.Account, error)) (
+	*sydney.Sydney, *sydney.Account, error) {
+	accountPool, err := a.getAccountPool()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	cookies, err := util.ReadCookiesFile()
-	if err != nil {
-		return nil, err
+	var account *sydney.Account
+	var cookies map[string]string
+	if accountPool != nil {
+		account, err = pick(accountPool)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		cookies, err = util.ReadCookiesFile()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var captchaSolver sydney.CaptchaSolver
+	if a.settings.config.CaptchaSolver != "" {
+		captchaSolver, err = sydney.ParseCaptchaSolver(a.settings.config.CaptchaSolver)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	return sydney.NewSydney(sydney.Options{
+	var clientProfiles []sydney.ClientProfile
+	if a.settings.config.ClientProfilesFile != "" {
+		clientProfiles, err = sydney.ReadClientProfiles(a.settings.config.ClientProfilesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	pool := a.getConversationPool()
+	options := sydney.Options{
 		Debug:                 a.settings.config.Debug,
 		Cookies:               cookies,
 		Proxy:                 a.settings.config.Proxy,
-		ConversationStyle:     currentWorkspace.ConversationStyle,
-		Locale:                currentWorkspace.Locale,
 		WssDomain:             a.settings.config.WssDomain,
 		CreateConversationURL: a.settings.config.CreateConversationURL,
-		NoSearch:              currentWorkspace.NoSearch,
-		UseClassic:            currentWorkspace.UseClassic,
-		GPT4Turbo:             currentWorkspace.GPT4Turbo,
+		Endpoints:             a.settings.config.Endpoints,
 		BypassServer:          a.settings.config.BypassServer,
-		Plugins:               currentWorkspace.Plugins,
-	}), nil
+		CaptchaSolver:         captchaSolver,
+		ClientProfile:         a.settings.config.ClientProfile,
+		CustomClientProfiles:  clientProfiles,
+		ConversationPool:      pool,
+	}
+	key := fmt.Sprintf("%v|%v|%s|%s|%s|%v|%v|%s|%s|%s|%p|%p", options.Debug, options.Cookies,
+		options.Proxy, options.WssDomain, options.CreateConversationURL, options.Endpoints,
+		options.BypassServer, a.settings.config.CaptchaSolver, options.ClientProfile,
+		a.settings.config.ClientProfilesFile, pool, accountPool)
+	a.sydneyMu.Lock()
+	defer a.sydneyMu.Unlock()
+	if a.sydneyKey != key {
+		a.sydneys = map[string]*sydney.Sydney{}
+		a.sydneyKey = key
+	}
+	accountName := ""
+	if account != nil {
+		accountName = account.Name
+		options = accountPool.Options(account, options)
+	}
+	if syd, ok := a.sydneys[accountName]; ok {
+		return syd, account, nil
+	}
+	syd := sydney.NewSydney(options)
+	a.sydneys[accountName] = syd
+	if pool != nil {
+		pool.Warm(syd)
+	}
+	return syd, account, nil
+}
+
+// workspaceChatOptions returns the chat options of the workspace, to be passed as
+// AskStreamOptions.ChatOptions.
+func workspaceChatOptions(workspace Workspace) sydney.ChatOptions {
+	return sydney.ChatOptions{
+		ConversationStyle: workspace.ConversationStyle,
+		Locale:            workspace.Locale,
+		NoSearch:          workspace.NoSearch,
+		UseClassic:        workspace.UseClassic,
+		GPT4Turbo:         workspace.GPT4Turbo,
+		Plugins:           workspace.Plugins,
+		Location: sydney.Location{
+			Preset:    workspace.Location,
+			Latitude:  workspace.Latitude,
+			Longitude: workspace.Longitude,
+			Country:   workspace.Country,
+			Region:    workspace.Region,
+		},
+	}
+}
+
+// getAccountPool returns the account pool of the configured directory, loading it again if the
+// settings have changed, or nil if disabled.
+func (a *App) getAccountPool() (*sydney.AccountPool, error) {
+	a.accountMu.Lock()
+	defer a.accountMu.Unlock()
+	key := a.settings.config.AccountsDir + "|" + a.settings.config.AccountStrategy
+	if a.accountPoolKey == key {
+		return a.accountPool, nil
+	}
+	a.accountPool = nil
+	if a.settings.config.AccountsDir != "" {
+		accountPool, err := sydney.NewAccountPool(sydney.AccountPoolOptions{
+			Strategy: sydney.AccountStrategy(a.settings.config.AccountStrategy),
+			Dir:      a.settings.config.AccountsDir,
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.accountPool = accountPool
+	}
+	a.accountPoolKey = key
+	return a.accountPool, nil
+}
+
+// reportAccount records the result of a request to the account if it is picked from the pool.
+func (a *App) reportAccount(account *sydney.Account, err error) {
+	if account == nil {
+		return
+	}
+	a.accountMu.Lock()
+	accountPool := a.accountPool
+	a.accountMu.Unlock()
+	if accountPool != nil {
+		accountPool.Report(account, err)
+	}
+}
+
+// trackAccount is like reportAccount, for an answer, see sydney.AccountPool.Track.
+func (a *App) trackAccount(ctx context.Context, account *sydney.Account,
+	ch <-chan sydney.Message) <-chan sydney.Message {
+	if account == nil {
+		return ch
+	}
+	a.accountMu.Lock()
+	accountPool := a.accountPool
+	a.accountMu.Unlock()
+	if accountPool == nil {
+		return ch
+	}
+	return accountPool.Track(ctx, account, ch)
+}
+
+// getConversationPool returns the conversation pool of the configured size, replacing the
+// current one if the size has changed, or nil if disabled.
+func (a *App) getConversationPool() *sydney.ConversationPool {
+	a.poolMu.Lock()
+	defer a.poolMu.Unlock()
+	size := a.settings.config.ConversationPoolSize
+	if a.conversationPool != nil && a.conversationPool.Options().Size == size {
+		return a.conversationPool
+	}
+	if a.conversationPool != nil {
+		a.conversationPool.Close()
+		a.conversationPool = nil
+	}
+	if size > 0 {
+		a.conversationPool = sydney.NewConversationPool(sydney.ConversationPoolOptions{Size: size})
+	}
+	return a.conversationPool
+}
+
+// sydneySession keeps a multi-turn Bing session alive for a workspace.
+type sydneySession struct {
+	session     *sydney.Session
+	account     *sydney.Account // nil unless picked from the account pool
+	optionsKey  string
+	chatContext string // the chat context sent in the last turn
+}
+
+// takeSydneySession returns the session of the workspace, the account it is made with and the
+// webpage context to send. The existing session is reused, with its account, only if the chat
+// context has merely been appended since the last turn and Bing still accepts more messages in
+// it; otherwise a new session is started with the full chat context.
+func (a *App) takeSydneySession(workspace Workspace, chatContext string) (
+	*sydney.Session, *sydney.Account, string, error) {
+	a.sessionMu.Lock()
+	defer a.sessionMu.Unlock()
+	optionsKey := fmt.Sprintf("%s|%s|%v|%v|%v|%v|%s|%v|%v|%s|%s", workspace.ConversationStyle,
+		workspace.Locale, workspace.NoSearch, workspace.UseClassic, workspace.GPT4Turbo, workspace.Plugins,
+		workspace.Location, workspace.Latitude, workspace.Longitude, workspace.Country, workspace.Region)
+	if s, ok := a.sydneySessions[workspace.ID]; ok && s.optionsKey == optionsKey &&
+		s.session.Turns() > 0 && !s.session.LimitReached() && strings.HasPrefix(chatContext, s.chatContext) {
+		slog.Info("Reuse sydney session", "workspace", workspace.ID, "turns", s.session.Turns())
+		s.chatContext = chatContext
+		return s.session, s.account, "", nil
+	}
+	sydneyIns, account, err := a.createSydney()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	s := &sydneySession{
+		session:     sydneyIns.NewSession(),
+		account:     account,
+		optionsKey:  optionsKey,
+		chatContext: chatContext,
+	}
+	a.sydneySessions[workspace.ID] = s
+	return s.session, s.account, chatContext, nil
+}
+
+// withNativeHistory moves the chat context of the options to History if enabled.
+func withNativeHistory(options sydney.AskStreamOptions, enabled bool) sydney.AskStreamOptions {
+	if enabled && options.WebpageContext != "" {
+		options.History = util.GetChatMessage(options.WebpageContext)
+		options.WebpageContext = ""
+	}
+	return options
+}
+func (a *App) dropSydneySession(workspaceID int) {
+	a.sessionMu.Lock()
+	defer a.sessionMu.Unlock()
+	delete(a.sydneySessions, workspaceID)
 }
 
 func (a *App) askSydney(options AskOptions) {
@@ -99,7 +339,7 @@ func (a *App) askSydney(options AskOptions) {
 		slog.Info("invoke EventChatFinish", "result", chatFinishResult)
 		runtime.EventsEmit(a.ctx, EventChatFinish, chatFinishResult)
 	}()
-	sydneyIns, err := a.createSydney()
+	currentWorkspace, err := a.settings.config.GetCurrentWorkspace()
 	if err != nil {
 		chatFinishResult = ChatFinishResult{
 			Success: false,
@@ -108,6 +348,7 @@ func (a *App) askSydney(options AskOptions) {
 		}
 		return
 	}
+	chatOptions := workspaceChatOptions(currentWorkspace)
 
 	stopCtx, cancel := util.CreateCancelContext()
 	defer cancel()
@@ -117,23 +358,61 @@ func (a *App) askSydney(options AskOptions) {
 		runtime.EventsOff(a.ctx, EventChatStop)
 	})
 
-	ch, err := sydneyIns.AskStream(sydney.AskStreamOptions{
+	askStreamOptions := sydney.AskStreamOptions{
 		StopCtx:        stopCtx,
 		Prompt:         options.Prompt,
 		WebpageContext: options.ChatContext,
 		ImageURL:       options.ImageURL,
 		UploadFilePath: options.UploadFilePath,
-	})
+		EmitRaw:        a.settings.config.Debug,
+		ChatOptions:    &chatOptions,
+	}
+	var ch <-chan sydney.Message
+	var account *sydney.Account
+	if a.settings.config.MultiTurnSession {
+		var session *sydney.Session
+		session, account, askStreamOptions.WebpageContext, err = a.takeSydneySession(currentWorkspace,
+			options.ChatContext)
+		if err != nil {
+			chatFinishResult = ChatFinishResult{
+				Success: false,
+				ErrType: ChatFinishResultErrTypeOthers,
+				ErrMsg:  err.Error(),
+			}
+			return
+		}
+		defer func() {
+			// a busy session is still answering, and is dropped by that answer if it fails
+			if !chatFinishResult.Success && !errors.Is(err, sydney.ErrSessionBusy) {
+				a.dropSydneySession(currentWorkspace.ID)
+			}
+		}()
+		ch, err = session.Ask(withNativeHistory(askStreamOptions, a.settings.config.NativeHistory))
+	} else {
+		var sydneyIns *sydney.Sydney
+		sydneyIns, account, err = a.createSydney()
+		if err != nil {
+			chatFinishResult = ChatFinishResult{
+				Success: false,
+				ErrType: ChatFinishResultErrTypeOthers,
+				ErrMsg:  err.Error(),
+			}
+			return
+		}
+		ch, err = sydneyIns.AskStream(withNativeHistory(askStreamOptions, a.settings.config.NativeHistory))
+	}
 	if err != nil {
+		a.reportAccount(account, err)
 		if !errors.Is(err, context.Canceled) {
 			chatFinishResult = ChatFinishResult{
 				Success: false,
-				ErrType: ChatFinishResultErrTypeOthers,
+				ErrType: chatFinishResultErrType(err),
 				ErrMsg:  err.Error(),
 			}
 		}
 		return
 	}
+	ch = a.trackAccount(stopCtx, account, ch)
 	runtime.EventsEmit(a.ctx, EventConversationCreated)
 
 	chatAppend := func(text string) {
@@ -148,24 +427,10 @@ func (a *App) askSydney(options AskOptions) {
 		case sydney.MessageTypeSuggestedResponses:
 			runtime.EventsEmit(a.ctx, EventChatSuggestedResponses, msg.Text)
 		case sydney.MessageTypeError:
-			if errors.Is(msg.Error, sydney.ErrMessageRevoke) {
-				chatFinishResult = ChatFinishResult{
-					Success: false,
-					ErrType: ChatFinishResultErrTypeMessageRevoke,
-					ErrMsg:  msg.Error.Error(),
-				}
-			} else if errors.Is(msg.Error, sydney.ErrMessageFiltered) {
-				chatFinishResult = ChatFinishResult{
-					Success: false,
-					ErrType: ChatFinishResultErrTypeMessageFiltered,
-					ErrMsg:  msg.Error.Error(),
-				}
-			} else {
-				chatFinishResult = ChatFinishResult{
-					Success: false,
-					ErrType: ChatFinishResultErrTypeOthers,
-					ErrMsg:  msg.Error.Error(),
-				}
+			chatFinishResult = ChatFinishResult{
+				Success: false,
+				ErrType: chatFinishResultErrType(msg.Error),
+				ErrMsg:  msg.Error.Error(),
 			}
 			return
 		case sydney.MessageTypeMessageText:
@@ -173,15 +438,11 @@ func (a *App) askSydney(options AskOptions) {
 			runtime.EventsEmit(a.ctx, EventChatToken, a.CountToken(fullMessageText))
 			textToAppend = msg.Text
 		case sydney.MessageTypeGenerativeImage:
-			var image sydney.GenerativeImage
-			lo.Must0(json.Unmarshal([]byte(msg.Text), &image))
-			runtime.EventsEmit(a.ctx, EventChatGenerateImage, image)
-			textToAppend = image.Text + "\n\n"
+			runtime.EventsEmit(a.ctx, EventChatGenerateImage, *msg.Image)
+			textToAppend = msg.Image.Text + "\n\n"
 		case sydney.MessageTypeGenerativeMusic:
-			var music sydney.GenerativeMusic
-			lo.Must0(json.Unmarshal([]byte(msg.Text), &music))
-			runtime.EventsEmit(a.ctx, EventChatGenerateMusic, music)
-			textToAppend = music.Text + "\n\n"
+			runtime.EventsEmit(a.ctx, EventChatGenerateMusic, *msg.Music)
+			textToAppend = msg.Music.Text + "\n\n"
 		case sydney.MessageTypeLoading:
 			if a.settings.config.DisableNoSearchLoader {
 				if msg.Text == "BingSearchDisabled" {
@@ -196,6 +457,17 @@ func (a *App) askSydney(options AskOptions) {
 			textToAppend = msg.Text + "\n\n"
 		case sydney.MessageTypeResolvingCaptcha:
 			runtime.EventsEmit(a.ctx, EventChatResolvingCaptcha, msg.Text)
+		case sydney.MessageTypeReconnecting:
+			runtime.EventsEmit(a.ctx, EventChatReconnecting, msg.Text)
+			continue // the answer goes on, so keep the current message block
+		case sydney.MessageTypeThrottling:
+			runtime.EventsEmit(a.ctx, EventChatThrottling, *msg.Throttling)
+			continue
+		case sydney.MessageTypeCodeArtifact:
+			// the code has been appended as generated_code, and the artifact is inserted as
+			// a data reference by the frontend
+			runtime.EventsEmit(a.ctx, EventChatCodeArtifact, *msg.CodeArtifact)
+			continue
 		default:
 			textToAppend = msg.Text + "\n\n"
 		}
@@ -324,27 +596,24 @@ type ConciseAnswerReq struct {
 
 func (a *App) GetConciseAnswer(req ConciseAnswerReq) (string, error) {
 	if req.Backend == "Sydney" {
-		cookies, err := util.ReadCookiesFile()
+		sydneyIns, account, err := a.createSydney()
 		if err != nil {
 			return "", err
 		}
-		syd := sydney.NewSydney(sydney.Options{
-			Debug:                 false,
-			Cookies:               cookies,
-			Proxy:                 a.settings.config.Proxy,
-			WssDomain:             a.settings.config.WssDomain,
-			CreateConversationURL: a.settings.config.CreateConversationURL,
-			NoSearch:              true,
-			UseClassic:            false,
-		})
-		ch, err := syd.AskStream(sydney.AskStreamOptions{
+		// the default chat options, independent of the current workspace
+		chatOptions := sydneyIns.ChatOptions()
+		chatOptions.NoSearch = true
+		ch, err := sydneyIns.AskStream(sydney.AskStreamOptions{
 			StopCtx:        context.Background(),
 			Prompt:         req.Prompt,
 			WebpageContext: req.Context,
+			ChatOptions:    &chatOptions,
 		})
 		if err != nil {
+			a.reportAccount(account, err)
 			return "", err
 		}
+		ch = a.trackAccount(context.Background(), account, ch)
 		var result bytes.Buffer
 		for msg := range ch {
 			if msg.Type == sydney.MessageTypeError {
@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"os"
+	"sydneyqt/sydney"
 	"sydneyqt/util"
 	"sync"
 	"time"
@@ -22,6 +23,11 @@ type Workspace struct {
 	Input             string          `json:"input"`
 	Backend           string          `json:"backend"`
 	Locale            string          `json:"locale"`
+	Location          string          `json:"location"` // name of a location preset
+	Latitude          float64         `json:"latitude"`
+	Longitude         float64         `json:"longitude"`
+	Country           string          `json:"country"`
+	Region            string          `json:"region"`
 	Preset            string          `json:"preset"`
 	ConversationStyle string          `json:"conversation_style"`
 	NoSearch          bool            `json:"no_search"`
@@ -50,30 +56,39 @@ type OpenAIBackend struct {
 	MaxTokens         int     `json:"max_tokens"`
 }
 type Config struct {
-	Debug                         bool            `json:"debug"`
-	Presets                       []Preset        `json:"presets"`
-	EnterMode                     string          `json:"enter_mode"`
-	Proxy                         string          `json:"proxy"`
-	NoSuggestion                  bool            `json:"no_suggestion"`
-	FontFamily                    string          `json:"font_family"`
-	FontSize                      int             `json:"font_size"`
-	StretchFactor                 int             `json:"stretch_factor"`
-	RevokeReplyText               string          `json:"revoke_reply_text"`
-	RevokeReplyCount              int             `json:"revoke_reply_count"`
-	Workspaces                    []Workspace     `json:"workspaces"`
-	CurrentWorkspaceID            int             `json:"current_workspace_id"`
-	Quick                         []string        `json:"quick"`
-	DisableDirectQuick            bool            `json:"disable_direct_quick"`
-	OpenAIBackends                []OpenAIBackend `json:"open_ai_backends"`
-	WssDomain                     string          `json:"wss_domain"`
-	DarkMode                      bool            `json:"dark_mode"`
-	NoImageRemovalAfterChat       bool            `json:"no_image_removal_after_chat"`
-	NoFileRemovalAfterChat        bool            `json:"no_file_removal_after_chat"`
-	CreateConversationURL         string          `json:"create_conversation_url"`
-	ThemeColor                    string          `json:"theme_color"`
-	DisableNoSearchLoader         bool            `json:"disable_no_search_loader"`
-	BypassServer                  string          `json:"bypass_server"`
-	DisableSummaryTitleGeneration bool            `json:"disable_summary_title_generation"`
+	Debug                         bool             `json:"debug"`
+	Presets                       []Preset         `json:"presets"`
+	EnterMode                     string           `json:"enter_mode"`
+	Proxy                         string           `json:"proxy"`
+	NoSuggestion                  bool             `json:"no_suggestion"`
+	FontFamily                    string           `json:"font_family"`
+	FontSize                      int              `json:"font_size"`
+	StretchFactor                 int              `json:"stretch_factor"`
+	RevokeReplyText               string           `json:"revoke_reply_text"`
+	RevokeReplyCount              int              `json:"revoke_reply_count"`
+	Workspaces                    []Workspace      `json:"workspaces"`
+	CurrentWorkspaceID            int              `json:"current_workspace_id"`
+	Quick                         []string         `json:"quick"`
+	DisableDirectQuick            bool             `json:"disable_direct_quick"`
+	OpenAIBackends                []OpenAIBackend  `json:"open_ai_backends"`
+	WssDomain                     string           `json:"wss_domain"`
+	DarkMode                      bool             `json:"dark_mode"`
+	NoImageRemovalAfterChat       bool             `json:"no_image_removal_after_chat"`
+	NoFileRemovalAfterChat        bool             `json:"no_file_removal_after_chat"`
+	CreateConversationURL         string           `json:"create_conversation_url"`
+	Endpoints                     sydney.Endpoints `json:"endpoints"`
+	ThemeColor                    string           `json:"theme_color"`
+	DisableNoSearchLoader         bool             `json:"disable_no_search_loader"`
+	BypassServer                  string           `json:"bypass_server"`
+	CaptchaSolver                 string           `json:"captcha_solver"`
+	ClientProfile                 string           `json:"client_profile"`
+	ClientProfilesFile            string           `json:"client_profiles_file"`
+	DisableSummaryTitleGeneration bool             `json:"disable_summary_title_generation"`
+	MultiTurnSession              bool             `json:"multi_turn_session"`
+	NativeHistory                 bool             `json:"native_history"`
+	ConversationPoolSize          int              `json:"conversation_pool_size"`
+	AccountsDir                   string           `json:"accounts_dir"`
+	AccountStrategy               string           `json:"account_strategy"`
 
 	Migration Migration `json:"migration"`
 }
@@ -218,6 +233,12 @@ func (o *Settings) SetConfig(config Config) {
 	if o.config.Debug != config.Debug {
 		o.DebugChangeSignal <- config.Debug
 	}
+	if o.config.Proxy != config.Proxy {
+		util.DefaultHTTPClientFactory.Release(o.config.Proxy)
+		if config.Proxy == "" {
+			go util.DefaultHTTPClientFactory.RefreshSystemProxy() // the system proxy may have changed since
+		}
+	}
 	o.config = config
 	o.version++
 }
@@ -21,8 +21,14 @@ export function GenerateMusic(arg1:sydney.GenerativeMusic):Promise<sydney.Genera
 
 export function GetConciseAnswer(arg1:main.ConciseAnswerReq):Promise<string>;
 
+export function GetLocationPresets():Promise<Array<string>>;
+
 export function GetUser():Promise<string>;
 
+export function RunDiagnostics():Promise<sydney.DiagnosticReport>;
+
+export function SaveCodeArtifact(arg1:sydney.CodeArtifact):Promise<string>;
+
 export function SaveRemoteFile(arg1:string,arg2:string,arg3:string):Promise<void>;
 
 export function SaveRemoteJPEGImage(arg1:string):Promise<void>;
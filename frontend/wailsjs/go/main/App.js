@@ -38,10 +38,22 @@ export function GetConciseAnswer(arg1) {
   return window['go']['main']['App']['GetConciseAnswer'](arg1);
 }
 
+export function GetLocationPresets() {
+  return window['go']['main']['App']['GetLocationPresets']();
+}
+
 export function GetUser() {
   return window['go']['main']['App']['GetUser']();
 }
 
+export function RunDiagnostics() {
+  return window['go']['main']['App']['RunDiagnostics']();
+}
+
+export function SaveCodeArtifact(arg1) {
+  return window['go']['main']['App']['SaveCodeArtifact'](arg1);
+}
+
 export function SaveRemoteFile(arg1, arg2, arg3) {
   return window['go']['main']['App']['SaveRemoteFile'](arg1, arg2, arg3);
 }
@@ -143,6 +143,11 @@ export namespace main {
 	    input: string;
 	    backend: string;
 	    locale: string;
+	    location: string;
+	    latitude: number;
+	    longitude: number;
+	    country: string;
+	    region: string;
 	    preset: string;
 	    conversation_style: string;
 	    no_search: boolean;
@@ -166,6 +171,11 @@ export namespace main {
 	        this.input = source["input"];
 	        this.backend = source["backend"];
 	        this.locale = source["locale"];
+	        this.location = source["location"];
+	        this.latitude = source["latitude"];
+	        this.longitude = source["longitude"];
+	        this.country = source["country"];
+	        this.region = source["region"];
 	        this.preset = source["preset"];
 	        this.conversation_style = source["conversation_style"];
 	        this.no_search = source["no_search"];
@@ -230,10 +240,19 @@ export namespace main {
 	    no_image_removal_after_chat: boolean;
 	    no_file_removal_after_chat: boolean;
 	    create_conversation_url: string;
+	    endpoints: sydney.Endpoints;
 	    theme_color: string;
 	    disable_no_search_loader: boolean;
 	    bypass_server: string;
+	    captcha_solver: string;
+	    client_profile: string;
+	    client_profiles_file: string;
 	    disable_summary_title_generation: boolean;
+	    multi_turn_session: boolean;
+	    native_history: boolean;
+	    conversation_pool_size: number;
+	    accounts_dir: string;
+	    account_strategy: string;
 	    migration: Migration;
 	
 	    static createFrom(source: any = {}) {
@@ -262,10 +281,19 @@ export namespace main {
 	        this.no_image_removal_after_chat = source["no_image_removal_after_chat"];
 	        this.no_file_removal_after_chat = source["no_file_removal_after_chat"];
 	        this.create_conversation_url = source["create_conversation_url"];
+	        this.endpoints = this.convertValues(source["endpoints"], sydney.Endpoints);
 	        this.theme_color = source["theme_color"];
 	        this.disable_no_search_loader = source["disable_no_search_loader"];
 	        this.bypass_server = source["bypass_server"];
+	        this.captcha_solver = source["captcha_solver"];
+	        this.client_profile = source["client_profile"];
+	        this.client_profiles_file = source["client_profiles_file"];
 	        this.disable_summary_title_generation = source["disable_summary_title_generation"];
+	        this.multi_turn_session = source["multi_turn_session"];
+	        this.native_history = source["native_history"];
+	        this.conversation_pool_size = source["conversation_pool_size"];
+	        this.accounts_dir = source["accounts_dir"];
+	        this.account_strategy = source["account_strategy"];
 	        this.migration = this.convertValues(source["migration"], Migration);
 	    }
 	
@@ -342,6 +370,120 @@ export namespace main {
 
 export namespace sydney {
 	
+	export class CodeArtifact {
+	    language: string;
+	    source: string;
+	    stdout: string;
+	    images: string[];
+	    files: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new CodeArtifact(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.language = source["language"];
+	        this.source = source["source"];
+	        this.stdout = source["stdout"];
+	        this.images = source["images"];
+	        this.files = source["files"];
+	    }
+	}
+	export class DiagnosticReport {
+	    ok: boolean;
+	    failed_step: string;
+	    steps: DiagnosticStep[];
+	    duration_ms: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiagnosticReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ok = source["ok"];
+	        this.failed_step = source["failed_step"];
+	        this.steps = this.convertValues(source["steps"], DiagnosticStep);
+	        this.duration_ms = source["duration_ms"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DiagnosticStep {
+	    name: string;
+	    ok: boolean;
+	    skipped: boolean;
+	    detail: string;
+	    error: string;
+	    duration_ms: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiagnosticStep(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.ok = source["ok"];
+	        this.skipped = source["skipped"];
+	        this.detail = source["detail"];
+	        this.error = source["error"];
+	        this.duration_ms = source["duration_ms"];
+	    }
+	}
+	export class Endpoints {
+	    chat_hub: string;
+	    create_conversation: string;
+	    image_upload: string;
+	    image_blob: string;
+	    file_upload: string;
+	    image_create: string;
+	    image_create_results: string;
+	    music_page: string;
+	    music_api: string;
+	    thumbnail: string;
+	    captcha_challenge: string;
+	    captcha_verify: string;
+	    get_user: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Endpoints(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.chat_hub = source["chat_hub"];
+	        this.create_conversation = source["create_conversation"];
+	        this.image_upload = source["image_upload"];
+	        this.image_blob = source["image_blob"];
+	        this.file_upload = source["file_upload"];
+	        this.image_create = source["image_create"];
+	        this.image_create_results = source["image_create_results"];
+	        this.music_page = source["music_page"];
+	        this.music_api = source["music_api"];
+	        this.thumbnail = source["thumbnail"];
+	        this.captcha_challenge = source["captcha_challenge"];
+	        this.captcha_verify = source["captcha_verify"];
+	        this.get_user = source["get_user"];
+	    }
+	}
 	export class GenerateImageResult {
 	    text: string;
 	    url: string;
@@ -0,0 +1,295 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sydneyqt/util"
+	"sync"
+	"time"
+)
+
+type AccountStrategy string
+
+const (
+	AccountStrategyRoundRobin     AccountStrategy = "round_robin"
+	AccountStrategyLeastThrottled AccountStrategy = "least_recently_throttled"
+)
+
+const (
+	AccountFailureCaptcha      = "captcha"
+	AccountFailureThrottled    = "throttled"
+	AccountFailureUnauthorized = "unauthorized"
+)
+
+var (
+	ErrNoAccountAvailable  = errors.New("all accounts are cooling down")
+	ErrNoAccountConfigured = errors.New("no accounts configured")
+)
+
+type AccountPoolOptions struct {
+	Strategy AccountStrategy // AccountStrategyRoundRobin if empty
+	// Cooldown is how long an account is skipped after a CAPTCHA, throttling or auth failure.
+	// It doubles with each consecutive failure, up to 16 times. 10 minutes if zero.
+	Cooldown time.Duration
+	// Dir is where the accounts are loaded from, one cookies file named <account>.json each,
+	// in the same format as cookies.json. Refreshed cookies are saved back to the files.
+	// Accounts are only added by AccountPool.Add if empty.
+	Dir string
+}
+
+// Account is a named cookie set in an AccountPool.
+type Account struct {
+	Name string
+
+	cookies       map[string]string
+	file          string
+	lastUsed      time.Time
+	lastFailure   time.Time
+	failureReason string
+	failures      int // consecutive failures
+	cooldownUntil time.Time
+	throttling    *Throttling
+}
+
+type AccountStatus struct {
+	Name          string    `json:"name"`
+	Available     bool      `json:"available"`
+	CooldownUntil time.Time `json:"cooldown_until"`
+	LastUsed      time.Time `json:"last_used"`
+	LastFailure   time.Time `json:"last_failure"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	Failures      int       `json:"failures"`
+	// Throttling is the usage of the conversation in the last answer made with the account.
+	Throttling *Throttling `json:"throttling,omitempty"`
+}
+
+// AccountPool holds several accounts and picks one for each request, skipping accounts that
+// are cooling down after a failure. It is safe for concurrent use.
+type AccountPool struct {
+	mu       sync.Mutex
+	accounts []*Account
+	strategy AccountStrategy
+	cooldown time.Duration
+	next     int
+	now      func() time.Time
+}
+
+func NewAccountPool(options AccountPoolOptions) (*AccountPool, error) {
+	o := &AccountPool{
+		strategy: util.Ternary(options.Strategy == "", AccountStrategyRoundRobin, options.Strategy),
+		cooldown: util.Ternary(options.Cooldown == 0, 10*time.Minute, options.Cooldown),
+		now:      time.Now,
+	}
+	if o.strategy != AccountStrategyRoundRobin && o.strategy != AccountStrategyLeastThrottled {
+		return nil, errors.New("unknown account strategy: " + string(o.strategy))
+	}
+	if options.Dir == "" {
+		return o, nil
+	}
+	files, err := filepath.Glob(filepath.Join(options.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		cookies, err := util.ReadCookiesFileAt(file)
+		if err != nil {
+			return nil, errors.New("cannot read cookies of account " + file + ": " + err.Error())
+		}
+		o.accounts = append(o.accounts, &Account{
+			Name:    strings.TrimSuffix(filepath.Base(file), ".json"),
+			cookies: cookies,
+			file:    file,
+		})
+	}
+	if len(o.accounts) == 0 {
+		return nil, errors.New("no account found in " + options.Dir)
+	}
+	slog.Info("Loaded accounts", "dir", options.Dir, "count", len(o.accounts))
+	return o, nil
+}
+
+// Add adds an account which is not persisted.
+func (o *AccountPool) Add(name string, cookies map[string]string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.accounts = append(o.accounts, &Account{Name: name, cookies: util.CopyMap(cookies)})
+}
+
+// Pick returns the next account not cooling down according to the strategy.
+func (o *AccountPool) Pick() (*Account, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	picked, next, err := o.pick()
+	if err != nil {
+		return nil, err
+	}
+	o.next = next
+	picked.lastUsed = o.now()
+	return picked, nil
+}
+
+// Peek returns the account Pick would return, without taking it, so that it can be checked,
+// e.g. by diagnostics, without moving the rotation.
+func (o *AccountPool) Peek() (*Account, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	picked, _, err := o.pick()
+	return picked, err
+}
+
+// pick returns the next account according to the strategy and the next round-robin position
+// after it. The caller must hold mu.
+func (o *AccountPool) pick() (*Account, int, error) {
+	if len(o.accounts) == 0 {
+		return nil, 0, ErrNoAccountConfigured
+	}
+	now := o.now()
+	var picked *Account
+	next := o.next
+	switch o.strategy {
+	case AccountStrategyRoundRobin:
+		for i := 0; i < len(o.accounts); i++ {
+			account := o.accounts[(o.next+i)%len(o.accounts)]
+			if now.Before(account.cooldownUntil) {
+				continue
+			}
+			picked = account
+			next = (o.next + i + 1) % len(o.accounts)
+			break
+		}
+	case AccountStrategyLeastThrottled:
+		for _, account := range o.accounts {
+			if now.Before(account.cooldownUntil) {
+				continue
+			}
+			if picked == nil || account.lastFailure.Before(picked.lastFailure) ||
+				account.lastFailure.Equal(picked.lastFailure) && account.lastUsed.Before(picked.lastUsed) {
+				picked = account
+			}
+		}
+	}
+	if picked == nil {
+		return nil, 0, ErrNoAccountAvailable
+	}
+	return picked, next, nil
+}
+
+// Options returns the options to create a Sydney with the account, whose refreshed cookies are
+// saved back to the pool.
+func (o *AccountPool) Options(account *Account, options Options) Options {
+	o.mu.Lock()
+	options.Cookies = util.CopyMap(account.cookies)
+	o.mu.Unlock()
+	options.OnCookiesUpdated = func(cookies map[string]string) {
+		o.updateCookies(account, cookies)
+	}
+	return options
+}
+
+func (o *AccountPool) updateCookies(account *Account, cookies map[string]string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k, v := range cookies {
+		account.cookies[k] = v
+	}
+	if account.file == "" {
+		return
+	}
+	if err := util.UpdateCookiesFileAt(account.file, account.cookies); err != nil {
+		slog.Warn("Cannot update cookies file of account", "account", account.Name, "err", err)
+	}
+}
+
+// Report records the result of a request made with the account. Accounts failing because of a
+// CAPTCHA, throttling or auth go into a cooldown; other errors, e.g. network ones, are ignored.
+func (o *AccountPool) Report(account *Account, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err == nil {
+		account.failures = 0
+		return
+	}
+	reason := accountFailureReason(err)
+	if reason == "" {
+		return
+	}
+	account.failures++
+	account.failureReason = reason
+	account.lastFailure = o.now()
+	account.cooldownUntil = account.lastFailure.Add(o.cooldown << min(account.failures-1, 4))
+	slog.Warn("Account is cooling down", "account", account.Name, "reason", reason,
+		"until", account.cooldownUntil, "err", err)
+}
+
+// Track forwards the messages of the channel, records the throttling of the answer to the
+// account, and reports the result to the pool when the channel is closed. It stops forwarding
+// when the context is done, which should also stop the sender of the channel. An answer which
+// ends without a final message, e.g. because it is canceled, is not reported.
+func (o *AccountPool) Track(ctx context.Context, account *Account, ch <-chan Message) <-chan Message {
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		var err error
+		finished := false
+		defer func() {
+			if !finished || err == nil && ctx.Err() != nil {
+				return
+			}
+			o.Report(account, err)
+		}()
+		for msg := range ch {
+			switch msg.Type {
+			case MessageTypeError:
+				err = util.Ternary(msg.Error != nil, msg.Error, errors.New(msg.Text))
+				finished = true
+			case MessageTypeThrottling, MessageTypeSuggestedResponses:
+				// decoded from the final update of the answer
+				finished = true
+			}
+			if msg.Throttling != nil {
+				o.mu.Lock()
+				account.throttling = msg.Throttling
+				o.mu.Unlock()
+			}
+			if !send(ctx, out, msg) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (o *AccountPool) Status() []AccountStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	return util.Map(o.accounts, func(account *Account) AccountStatus {
+		return AccountStatus{
+			Name:          account.Name,
+			Available:     !now.Before(account.cooldownUntil),
+			CooldownUntil: account.cooldownUntil,
+			LastUsed:      account.lastUsed,
+			LastFailure:   account.lastFailure,
+			FailureReason: account.failureReason,
+			Failures:      account.failures,
+			Throttling:    account.throttling,
+		}
+	})
+}
+
+func accountFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCaptchaRequired):
+		return AccountFailureCaptcha
+	case errors.Is(err, ErrThrottled):
+		return AccountFailureThrottled
+	case errors.Is(err, ErrUnauthorized):
+		return AccountFailureUnauthorized
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sydneyqt/util"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAccountPool(t *testing.T, strategy AccountStrategy, names ...string) (*AccountPool, *time.Time) {
+	pool, err := NewAccountPool(AccountPoolOptions{Strategy: strategy, Cooldown: time.Minute})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time {
+		return now
+	}
+	for _, name := range names {
+		pool.Add(name, map[string]string{"_U": name})
+	}
+	return pool, &now
+}
+
+func pickNames(t *testing.T, pool *AccountPool, n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		account, err := pool.Pick()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		names = append(names, account.Name)
+	}
+	return names
+}
+
+func TestAccountPool(t *testing.T) {
+	t.Run("round robin", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin, "a", "b", "c")
+		assert.Equal(t, []string{"a", "b", "c", "a"}, pickNames(t, pool, 4))
+	})
+	t.Run("peek", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin, "a", "b")
+		for i := 0; i < 2; i++ {
+			account, err := pool.Peek()
+			assert.Nil(t, err)
+			assert.Equal(t, "a", account.Name)
+		}
+		assert.True(t, pool.Status()[0].LastUsed.IsZero())
+		assert.Equal(t, []string{"a", "b"}, pickNames(t, pool, 2))
+	})
+	t.Run("cooldown", func(t *testing.T) {
+		pool, now := newTestAccountPool(t, AccountStrategyRoundRobin, "a", "b")
+		account, _ := pool.Pick()
+		pool.Report(account, newResultError("CaptchaChallenge", "User needs to solve CAPTCHA to continue."))
+		assert.Equal(t, []string{"b", "b"}, pickNames(t, pool, 2))
+		status := pool.Status()
+		assert.False(t, status[0].Available)
+		assert.Equal(t, AccountFailureCaptcha, status[0].FailureReason)
+		*now = now.Add(time.Minute)
+		assert.Equal(t, []string{"a", "b"}, pickNames(t, pool, 2))
+	})
+	t.Run("cooldown grows with consecutive failures", func(t *testing.T) {
+		pool, now := newTestAccountPool(t, AccountStrategyRoundRobin, "a")
+		account, _ := pool.Pick()
+		pool.Report(account, newResultError("Throttled", "Request is throttled."))
+		*now = now.Add(time.Minute)
+		account, _ = pool.Pick()
+		pool.Report(account, newResultError("Throttled", "Request is throttled."))
+		*now = now.Add(time.Minute)
+		_, err := pool.Pick()
+		assert.ErrorIs(t, err, ErrNoAccountAvailable)
+		*now = now.Add(time.Minute)
+		account, err = pool.Pick()
+		assert.Nil(t, err)
+		pool.Report(account, nil)
+		assert.Equal(t, 0, pool.Status()[0].Failures)
+	})
+	t.Run("other errors", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin, "a")
+		account, _ := pool.Pick()
+		pool.Report(account, errors.New("dial tcp: i/o timeout"))
+		assert.True(t, pool.Status()[0].Available)
+	})
+	t.Run("least recently throttled", func(t *testing.T) {
+		pool, now := newTestAccountPool(t, AccountStrategyLeastThrottled, "a", "b", "c")
+		for _, name := range []string{"b", "c", "a"} {
+			for _, account := range pool.accounts {
+				if account.Name == name {
+					pool.Report(account, newResultError("Throttled", "Request is throttled."))
+				}
+			}
+			*now = now.Add(time.Minute)
+		}
+		*now = now.Add(time.Hour)
+		assert.Equal(t, []string{"b", "b"}, pickNames(t, pool, 2))
+		// accounts never throttled are picked by the time of last use
+		pool, now = newTestAccountPool(t, AccountStrategyLeastThrottled, "a", "b")
+		for _, name := range []string{"a", "b", "a"} {
+			*now = now.Add(time.Second)
+			assert.Equal(t, []string{name}, pickNames(t, pool, 1))
+		}
+	})
+	t.Run("track", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin, "a")
+		account, _ := pool.Pick()
+		ch := make(chan Message, 3)
+		ch <- Message{Type: MessageTypeMessageText, Text: "Hello"}
+		ch <- Message{Type: MessageTypeThrottling, Throttling: &Throttling{
+			MaxNumUserMessagesInConversation: 30,
+			NumUserMessagesInConversation:    30,
+		}}
+		err := newResultError("Throttled", "Request is throttled.")
+		ch <- Message{Type: MessageTypeError, Text: err.Error(), Error: err}
+		close(ch)
+		assert.Len(t, collect(pool.Track(context.Background(), account, ch)), 3)
+		status := pool.Status()[0]
+		assert.Equal(t, AccountFailureThrottled, status.FailureReason)
+		assert.Equal(t, 0, status.Throttling.RemainingUserMessages())
+	})
+	t.Run("track skips unfinished answers", func(t *testing.T) {
+		pool, now := newTestAccountPool(t, AccountStrategyRoundRobin, "a")
+		account, _ := pool.Pick()
+		pool.Report(account, newResultError("Throttled", "Request is throttled."))
+		*now = now.Add(time.Minute)
+		ch := make(chan Message, 1)
+		ch <- Message{Type: MessageTypeMessageText, Text: "Hello"}
+		close(ch)
+		assert.Len(t, collect(pool.Track(context.Background(), account, ch)), 1)
+		assert.Equal(t, 1, pool.Status()[0].Failures)
+		ch = make(chan Message, 2)
+		ch <- Message{Type: MessageTypeMessageText, Text: "Hello"}
+		ch <- Message{Type: MessageTypeSuggestedResponses, Text: "[]"}
+		close(ch)
+		assert.Len(t, collect(pool.Track(context.Background(), account, ch)), 2)
+		assert.Equal(t, 0, pool.Status()[0].Failures)
+	})
+	t.Run("track stops when the context is done", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin, "a")
+		account, _ := pool.Pick()
+		ch := make(chan Message, 1)
+		ch <- Message{Type: MessageTypeMessageText, Text: "Hello"}
+		ctx, cancel := context.WithCancel(context.Background())
+		pool.Track(ctx, account, ch) // nobody reads the message
+		cancel()
+		assert.Eventually(t, func() bool {
+			buf := make([]byte, 1<<20)
+			return !strings.Contains(string(buf[:runtime.Stack(buf, true)]), "sydney.(*AccountPool).Track")
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+	t.Run("empty", func(t *testing.T) {
+		pool, _ := newTestAccountPool(t, AccountStrategyRoundRobin)
+		_, err := pool.Pick()
+		assert.ErrorIs(t, err, ErrNoAccountConfigured)
+	})
+}
+
+func TestAccountPoolDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alice", "bob"} {
+		assert.Nil(t, util.UpdateCookiesFileAt(filepath.Join(dir, name+".json"), map[string]string{"_U": name}))
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644))
+	pool, err := NewAccountPool(AccountPoolOptions{Dir: dir})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	account, err := pool.Pick()
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", account.Name)
+	syd := NewSydney(pool.Options(account, Options{}))
+	syd.UpdateModifiedCookies(map[string]string{"MUID": "refreshed"})
+	cookies, err := util.ReadCookiesFileAt(filepath.Join(dir, "alice.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"_U": "alice", "MUID": "refreshed"}, cookies)
+	cookies, err = util.ReadCookiesFileAt(filepath.Join(dir, "bob.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"_U": "bob"}, cookies)
+
+	_, err = NewAccountPool(AccountPoolOptions{Dir: t.TempDir()})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,204 @@
+package sydney
+
+import (
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+)
+
+// RenderAdaptiveCard converts the JSON of an adaptive card into Markdown. TextBlock,
+// RichTextBlock, Image, ImageSet, Container, ColumnSet, FactSet, Table and the OpenUrl
+// actions are supported, and other elements are left out.
+func RenderAdaptiveCard(card string) string {
+	value := gjson.Parse(card)
+	return joinBlocks(renderCardElements(value.Get("body")), renderCardActions(value.Get("actions")))
+}
+
+// joinBlocks joins the non-empty Markdown blocks with blank lines.
+func joinBlocks(blocks ...string) string {
+	return strings.Join(lo.Filter(blocks, func(item string, index int) bool {
+		return strings.TrimSpace(item) != ""
+	}), "\n\n")
+}
+
+func renderCardElements(elements gjson.Result) string {
+	var blocks []string
+	for _, element := range elements.Array() {
+		blocks = append(blocks, renderCardElement(element))
+	}
+	return joinBlocks(blocks...)
+}
+
+func renderCardElement(element gjson.Result) string {
+	switch element.Get("type").String() {
+	case "TextBlock":
+		return renderTextBlock(element)
+	case "RichTextBlock":
+		return strings.Join(lo.Map(element.Get("inlines").Array(), func(inline gjson.Result, index int) string {
+			if inline.Type == gjson.String {
+				return inline.String()
+			}
+			return renderTextRun(inline)
+		}), "")
+	case "Image":
+		return renderImage(element)
+	case "ImageSet":
+		return strings.Join(lo.Map(element.Get("images").Array(), func(image gjson.Result, index int) string {
+			return renderImage(image)
+		}), " ")
+	case "Container":
+		return renderCardElements(element.Get("items"))
+	case "ColumnSet":
+		var blocks []string
+		for _, column := range element.Get("columns").Array() {
+			blocks = append(blocks, renderCardElements(column.Get("items")))
+		}
+		return joinBlocks(blocks...)
+	case "FactSet":
+		return strings.Join(lo.Map(element.Get("facts").Array(), func(fact gjson.Result, index int) string {
+			return "- **" + fact.Get("title").String() + "**: " + fact.Get("value").String()
+		}), "\n")
+	case "Table":
+		return renderTable(element)
+	case "ActionSet":
+		return renderCardActions(element.Get("actions"))
+	}
+	return ""
+}
+
+func renderTextBlock(element gjson.Result) string {
+	text := strings.TrimSpace(element.Get("text").String())
+	if text == "" || strings.Contains(text, "\n") {
+		return text
+	}
+	size := strings.ToLower(element.Get("size").String())
+	if element.Get("style").String() == "heading" || size == "large" || size == "extralarge" {
+		return "### " + text
+	}
+	if strings.EqualFold(element.Get("weight").String(), "bolder") {
+		return "**" + text + "**"
+	}
+	return text
+}
+
+func renderTextRun(inline gjson.Result) string {
+	text := inline.Get("text").String()
+	if strings.TrimSpace(text) == "" {
+		return text
+	}
+	if strings.EqualFold(inline.Get("weight").String(), "bolder") {
+		text = "**" + text + "**"
+	}
+	if inline.Get("italic").Bool() {
+		text = "*" + text + "*"
+	}
+	if inline.Get("strikethrough").Bool() {
+		text = "~~" + text + "~~"
+	}
+	if link := openURL(inline.Get("selectAction")); link != "" {
+		text = "[" + text + "](" + link + ")"
+	}
+	return text
+}
+
+func renderImage(image gjson.Result) string {
+	src := image.Get("url").String()
+	if src == "" {
+		return ""
+	}
+	text := "![" + image.Get("altText").String() + "](" + src + ")"
+	if link := openURL(image.Get("selectAction")); link != "" {
+		text = "[" + text + "](" + link + ")"
+	}
+	return text
+}
+
+// renderTable renders the table as a Markdown table, of which the header is the first row
+// unless firstRowAsHeader is false.
+func renderTable(table gjson.Result) string {
+	rows := lo.Map(table.Get("rows").Array(), func(row gjson.Result, index int) []string {
+		return lo.Map(row.Get("cells").Array(), func(cell gjson.Result, index int) string {
+			text := renderCardElements(cell.Get("items"))
+			text = strings.ReplaceAll(text, "|", `\|`)
+			return strings.Join(strings.Fields(strings.ReplaceAll(text, "\n\n", "<br>")), " ")
+		})
+	})
+	if len(rows) == 0 {
+		return ""
+	}
+	width := len(table.Get("columns").Array())
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	if width == 0 {
+		return ""
+	}
+	header := make([]string, width)
+	if table.Get("firstRowAsHeader").Bool() || !table.Get("firstRowAsHeader").Exists() {
+		header = rows[0]
+		rows = rows[1:]
+	}
+	line := func(cells []string) string {
+		cells = append(cells, make([]string, max(0, width-len(cells)))...)
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+	lines := []string{line(header), line(lo.Times(width, func(index int) string { return "---" }))}
+	for _, row := range rows {
+		lines = append(lines, line(row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderCardActions(actions gjson.Result) string {
+	return strings.Join(lo.Compact(lo.Map(actions.Array(), func(action gjson.Result, index int) string {
+		link := openURL(action)
+		if link == "" {
+			return ""
+		}
+		return "- [" + lo.Ternary(action.Get("title").String() == "", link, action.Get("title").String()) +
+			"](" + link + ")"
+	})), "\n")
+}
+
+func openURL(action gjson.Result) string {
+	if action.Get("type").String() != "Action.OpenUrl" {
+		return ""
+	}
+	return action.Get("url").String()
+}
+
+// adaptiveCards renders the adaptive cards of the final bot messages, leaving out the text
+// block holding the answer, which has been streamed as text, and the "Learn more" footnotes,
+// which have been sent as search results.
+func adaptiveCards(messages gjson.Result) []Message {
+	var out []Message
+	for _, message := range messages.Array() {
+		if message.Get("author").String() != "bot" || message.Get("messageType").String() != "" {
+			continue
+		}
+		text := strings.TrimSpace(message.Get("text").String())
+		var blocks []string
+		for _, card := range message.Get("adaptiveCards").Array() {
+			var elements []string
+			for _, element := range card.Get("body").Array() {
+				if element.Get("type").String() == "TextBlock" {
+					blockText := strings.TrimSpace(element.Get("text").String())
+					if text != "" && strings.HasSuffix(blockText, text) ||
+						strings.HasPrefix(blockText, "Learn more:") {
+						continue
+					}
+				}
+				elements = append(elements, renderCardElement(element))
+			}
+			blocks = append(blocks, joinBlocks(elements...), renderCardActions(card.Get("actions")))
+		}
+		if markdown := joinBlocks(blocks...); markdown != "" {
+			out = append(out, Message{
+				Type: MessageTypeAdaptiveCard,
+				Text: markdown,
+			})
+		}
+	}
+	return out
+}
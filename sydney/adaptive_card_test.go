@@ -0,0 +1,40 @@
+package sydney
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderAdaptiveCard(t *testing.T) {
+	t.Run("text and images", func(t *testing.T) {
+		markdown := RenderAdaptiveCard(`{"type":"AdaptiveCard","body":[
+			{"type":"TextBlock","text":"Weather","size":"large"},
+			{"type":"TextBlock","text":"Sunny","weight":"bolder"},
+			{"type":"RichTextBlock","inlines":[{"type":"TextRun","text":"See "},{"type":"TextRun","text":"forecast","selectAction":{"type":"Action.OpenUrl","url":"https://example.com"}}]},
+			{"type":"ImageSet","images":[{"type":"Image","url":"https://example.com/a.png","altText":"a"},{"type":"Image","url":"https://example.com/b.png"}]},
+			{"type":"Unknown","text":"ignored"}
+		],"actions":[{"type":"Action.OpenUrl","title":"More","url":"https://example.com/more"},{"type":"Action.Submit","title":"Submit"}]}`)
+		assert.Equal(t, "### Weather\n\n**Sunny**\n\nSee [forecast](https://example.com)\n\n"+
+			"![a](https://example.com/a.png) ![](https://example.com/b.png)\n\n- [More](https://example.com/more)", markdown)
+	})
+	t.Run("layout", func(t *testing.T) {
+		markdown := RenderAdaptiveCard(`{"type":"AdaptiveCard","body":[
+			{"type":"ColumnSet","columns":[{"items":[{"type":"TextBlock","text":"Left"}]},{"items":[{"type":"Container","items":[{"type":"TextBlock","text":"Right"}]}]}]},
+			{"type":"FactSet","facts":[{"title":"High","value":"25°C"},{"title":"Low","value":"15°C"}]}
+		]}`)
+		assert.Equal(t, "Left\n\nRight\n\n- **High**: 25°C\n- **Low**: 15°C", markdown)
+	})
+	t.Run("table", func(t *testing.T) {
+		table := `{"type":"Table","columns":[{},{}],%s"rows":[
+			{"cells":[{"items":[{"type":"TextBlock","text":"Name"}]},{"items":[{"type":"TextBlock","text":"Value"}]}]},
+			{"cells":[{"items":[{"type":"TextBlock","text":"a|b"}]},{"items":[{"type":"TextBlock","text":"1"},{"type":"TextBlock","text":"2"}]}]},
+			{"cells":[{"items":[{"type":"TextBlock","text":"c"}]}]}
+		]}`
+		assert.Equal(t, "| Name | Value |\n| --- | --- |\n| a\\|b | 1<br>2 |\n| c |  |",
+			RenderAdaptiveCard(`{"body":[`+fmt.Sprintf(table, "")+`]}`))
+		assert.Equal(t, "|  |  |\n| --- | --- |\n| Name | Value |\n| a\\|b | 1<br>2 |\n| c |  |",
+			RenderAdaptiveCard(`{"body":[`+fmt.Sprintf(table, `"firstRowAsHeader":false,`)+`]}`))
+	})
+}
@@ -19,7 +19,10 @@ import (
 	"time"
 )
 
-func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
+// BrowserCaptchaSolver opens a visible browser on the CAPTCHA page for the user to solve it.
+type BrowserCaptchaSolver struct{}
+
+func (BrowserCaptchaSolver) SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (resCookies map[string]string, err error) {
 	defer func() {
 		if err0 := recover(); err0 != nil {
 			slog.Warn("Error resolving captcha", "err", err0)
@@ -27,17 +30,17 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 		}
 	}()
 	iframeID := uuid.New().String()
-	l := launcher.NewUserMode().Context(stopCtx).
+	l := launcher.NewUserMode().Context(ctx).
 		Leakless(true).
 		UserDataDir(filepath.Join(os.TempDir(), "rod-user-data-"+uuid.New().String())).
 		Set("disable-default-apps").
 		Set("no-first-run").Headless(false)
 	defer l.Cleanup()
 	u := l.MustLaunch()
-	browser := rod.New().Context(stopCtx).NoDefaultDevice().ControlURL(u).MustConnect()
+	browser := rod.New().Context(ctx).NoDefaultDevice().ControlURL(u).MustConnect()
 	defer browser.MustClose()
 	var cookies []*proto.NetworkCookie
-	for k, v := range o.cookies {
+	for k, v := range challenge.Cookies {
 		cookies = append(cookies, &proto.NetworkCookie{
 			Name:    k,
 			Value:   v,
@@ -48,7 +51,7 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 	}
 	browser.MustSetCookies(cookies...)
 	page := stealth.MustPage(browser)
-	page.MustNavigate("https://www.bing.com/turing/captcha/challenge?" +
+	page.MustNavigate(challenge.Endpoints.CaptchaChallenge + "?" +
 		"q=&iframeid=local-gen-" + iframeID)
 	page.MustElement("body")
 	page.MustEval("()=>{let info=document.createElement('h3');" +
@@ -57,8 +60,7 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 	router := page.HijackRequests()
 	waitCh := make(chan struct{}, 16)
 	defer close(waitCh)
-	var resCookies map[string]string
-	router.MustAdd("https://www.bing.com/challenge/verify*", func(hijack *rod.Hijack) {
+	router.MustAdd(challenge.Endpoints.CaptchaVerify+"*", func(hijack *rod.Hijack) {
 		hijack.MustLoadResponse()
 		for key, values := range hijack.Response.Headers() {
 			if strings.ToLower(key) != "set-cookie" {
@@ -76,67 +78,103 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 	defer router.Stop()
 	select {
 	case <-time.Tick(60 * time.Second):
-		return errors.New("timeout verifying challenge token")
-	case <-stopCtx.Done():
-		return stopCtx.Err()
+		return nil, errors.New("timeout verifying challenge token")
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	case <-waitCh:
 	}
 	slog.Info("Captcha resCookies", "v", resCookies)
-	if err := o.postprocessCaptchaCookies(resCookies); err != nil {
-		return err
-	}
-	return nil
+	return resCookies, nil
 }
-func (o *Sydney) BypassCaptcha(stopCtx context.Context, conversationID string, messageID string) error {
-	if o.bypassServer == "" {
-		return errors.New("no bypass server specified")
+
+// BypassServerCaptchaSolver asks a CAPTCHA-bypass server to solve the CAPTCHA.
+type BypassServerCaptchaSolver struct {
+	URL string
+}
+
+func (o BypassServerCaptchaSolver) SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	if o.URL == "" {
+		return nil, errors.New("no bypass server specified")
 	}
-	_, client, err := util.MakeHTTPClient(o.proxy, 60*time.Second)
+	_, client, err := util.MakeHTTPClient(challenge.Proxy, 60*time.Second)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req := BypassCaptchaRequest{
 		IG:       hex.NewUpperHex(32),
-		Cookies:  util.FormatCookieString(o.cookies),
+		Cookies:  util.FormatCookieString(challenge.Cookies),
 		IFrameID: "local-gen-" + uuid.New().String(),
-		ConvID:   conversationID,
-		RID:      messageID,
+		ConvID:   challenge.ConversationID,
+		RID:      challenge.MessageID,
 	}
 	slog.Debug("Bypass CAPTCHA request", "v", req)
-	resp, err := client.R().SetContext(stopCtx).SetBody(req).Post(o.bypassServer)
+	resp, err := client.R().SetContext(ctx).SetBody(req).Post(o.URL)
 	if err != nil {
-		return fmt.Errorf("cannot communicate with captcha bypass server: %w", err)
+		return nil, fmt.Errorf("cannot communicate with captcha bypass server: %w", err)
 	}
 	slog.Debug("Bypass captcha response body", "v", resp.String())
 	var response BypassCaptchaResponse
 	err = json.Unmarshal(resp.Bytes(), &response)
 	if err != nil {
-		return fmt.Errorf("cannot unmarshal json from captcha bypass server: %w", err)
+		return nil, fmt.Errorf("cannot unmarshal json from captcha bypass server: %w", err)
 	}
 	if response.Error != "" {
-		return errors.New("bypass captcha error: " + response.Error)
+		return nil, errors.New("bypass captcha error: " + response.Error)
 	}
 	cookies := util.ParseCookiesFromString(response.Result.Cookies)
-	if err := o.postprocessCaptchaCookies(cookies); err != nil {
-		return fmt.Errorf("%w; screenshot: "+
-			strings.TrimSuffix(o.bypassServer, "/")+
+	if err := validateCaptchaCookies(cookies); err != nil {
+		return nil, fmt.Errorf("%w; screenshot: "+
+			strings.TrimSuffix(o.URL, "/")+
 			response.Result.ScreenShot, err)
 	}
+	return cookies, nil
+}
+
+// ResolveCaptcha solves the CAPTCHA in a visible browser and updates the cookies.
+func (o *Sydney) ResolveCaptcha(stopCtx context.Context) error {
+	return o.solveCaptcha(stopCtx, BrowserCaptchaSolver{}, "", "")
+}
+
+// BypassCaptcha solves the CAPTCHA with the bypass server and updates the cookies.
+func (o *Sydney) BypassCaptcha(stopCtx context.Context, conversationID string, messageID string) error {
+	return o.solveCaptcha(stopCtx, BypassServerCaptchaSolver{URL: o.bypassServer}, conversationID, messageID)
+}
+func (o *Sydney) solveCaptcha(ctx context.Context, solver CaptchaSolver, conversationID string, messageID string) error {
+	cookies, err := solver.SolveCaptcha(ctx, CaptchaChallenge{
+		Cookies:        o.copyCookies(),
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Endpoints:      o.endpoints,
+		Proxy:          o.proxy,
+	})
+	if err != nil {
+		return err
+	}
+	if err := validateCaptchaCookies(cookies); err != nil {
+		return err
+	}
+	o.UpdateModifiedCookies(cookies)
 	return nil
 }
 func (o *Sydney) UpdateModifiedCookies(modifiedCookies map[string]string) {
+	o.cookiesMu.Lock()
 	for k, v := range modifiedCookies { // keep the map pointer
 		o.cookies[k] = v
 	}
-	err := util.UpdateCookiesFile(o.cookies)
+	cookies := util.CopyMap(o.cookies)
+	o.cookiesMu.Unlock()
+	if o.onCookiesUpdated != nil {
+		o.onCookiesUpdated(cookies)
+		return
+	}
+	err := util.UpdateCookiesFile(cookies)
 	if err != nil {
 		slog.Warn("Cannot update cookies file: ", "err", err)
 	}
 }
-func (o *Sydney) postprocessCaptchaCookies(modifiedCookies map[string]string) error {
-	if _, ok := modifiedCookies["cct"]; !ok {
+func validateCaptchaCookies(cookies map[string]string) error {
+	if _, ok := cookies["cct"]; !ok {
 		return errors.New("captcha cookies not valid: no cookie named cct found")
 	}
-	o.UpdateModifiedCookies(modifiedCookies)
 	return nil
 }
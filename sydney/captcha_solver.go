@@ -0,0 +1,123 @@
+package sydney
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sydneyqt/util"
+	"time"
+)
+
+// CaptchaChallenge is the CAPTCHA Bing asks the account to solve.
+type CaptchaChallenge struct {
+	Cookies        map[string]string
+	ConversationID string
+	MessageID      string
+	Endpoints      Endpoints
+	Proxy          string // a proxy URL or a util.ProxyConfig in JSON
+}
+
+// CaptchaSolver solves a CAPTCHA and returns the cookies to update, which must include cct.
+type CaptchaSolver interface {
+	SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error)
+}
+
+// FailFastCaptchaSolver doesn't solve any CAPTCHA, for servers where nobody can solve it.
+type FailFastCaptchaSolver struct{}
+
+func (FailFastCaptchaSolver) SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	return nil, errors.New("solving CAPTCHA is disabled")
+}
+
+// CommandCaptchaSolver runs an external command to solve the CAPTCHA. The command reads the
+// challenge as JSON from stdin, and writes the cookies to stdout, either as a cookie string
+// like "cct=...; _U=..." or as a JSON object.
+type CommandCaptchaSolver struct {
+	Name    string
+	Args    []string
+	Timeout time.Duration // 2 minutes if zero
+}
+
+type commandCaptchaInput struct {
+	Cookies        string `json:"cookies"`
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	ChallengeURL   string `json:"challenge_url"`
+	VerifyURL      string `json:"verify_url"`
+	Proxy          string `json:"proxy"`
+}
+
+func (o CommandCaptchaSolver) SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, util.Ternary(o.Timeout == 0, 2*time.Minute, o.Timeout))
+	defer cancel()
+	proxy, err := util.DefaultHTTPClientFactory.ProxyURL(challenge.Proxy, challenge.Endpoints.CaptchaChallenge)
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(commandCaptchaInput{
+		Cookies:        util.FormatCookieString(challenge.Cookies),
+		ConversationID: challenge.ConversationID,
+		MessageID:      challenge.MessageID,
+		ChallengeURL:   challenge.Endpoints.CaptchaChallenge,
+		VerifyURL:      challenge.Endpoints.CaptchaVerify,
+		Proxy:          proxy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, o.Name, o.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("captcha solver command failed: %w; stderr: %s", err,
+			strings.TrimSpace(stderr.String()))
+	}
+	output := strings.TrimSpace(stdout.String())
+	slog.Debug("Captcha solver command output", "v", output)
+	if strings.HasPrefix(output, "{") {
+		var cookies map[string]string
+		if err := json.Unmarshal([]byte(output), &cookies); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal cookies from captcha solver command: %w", err)
+		}
+		return cookies, nil
+	}
+	return util.ParseCookiesFromString(output), nil
+}
+
+// ParseCaptchaSolver returns the solver described by the spec, one of:
+//
+//   - browser: open a visible browser for the user to solve the CAPTCHA
+//   - fail_fast: don't solve the CAPTCHA
+//   - bypass:<url>: ask the CAPTCHA-bypass server at the URL
+//   - command:<command line>: run the command, whose arguments are separated by spaces
+func ParseCaptchaSolver(spec string) (CaptchaSolver, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "browser":
+		return BrowserCaptchaSolver{}, nil
+	case "fail_fast":
+		return FailFastCaptchaSolver{}, nil
+	case "bypass":
+		if arg == "" {
+			return nil, errors.New("no bypass server specified")
+		}
+		return BypassServerCaptchaSolver{URL: arg}, nil
+	case "command":
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			return nil, errors.New("no captcha solver command specified")
+		}
+		return CommandCaptchaSolver{Name: fields[0], Args: fields[1:]}, nil
+	}
+	return nil, errors.New("unknown captcha solver: " + spec)
+}
@@ -0,0 +1,120 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sydneyqt/sydney/sydneytest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubCaptchaSolver struct {
+	cookies    map[string]string
+	err        error
+	challenges []CaptchaChallenge
+}
+
+func (o *stubCaptchaSolver) SolveCaptcha(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	o.challenges = append(o.challenges, challenge)
+	return o.cookies, o.err
+}
+
+func TestCaptchaSolver(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	ask := func(solver CaptchaSolver, updated *map[string]string) []Message {
+		syd := NewSydney(Options{
+			Cookies:       map[string]string{"_U": "fake"},
+			Endpoints:     LocalEndpoints(server.URL),
+			CaptchaSolver: solver,
+			OnCookiesUpdated: func(cookies map[string]string) {
+				*updated = cookies
+			},
+		})
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return collect(ch)
+	}
+	t.Run("solved", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario(), sydneytest.TextScenario("Solved"))
+		solver := &stubCaptchaSolver{cookies: map[string]string{"cct": "solved"}}
+		var updated map[string]string
+		messages := ask(solver, &updated)
+		_, ok := findMessage(messages, MessageTypeResolvingCaptcha)
+		assert.True(t, ok)
+		assert.Equal(t, "Solved", messageText(messages))
+		assert.Len(t, solver.challenges, 1)
+		assert.Equal(t, "fake", solver.challenges[0].Cookies["_U"])
+		assert.NotEmpty(t, solver.challenges[0].ConversationID)
+		assert.Equal(t, map[string]string{"_U": "fake", "cct": "solved"}, updated)
+	})
+	t.Run("invalid cookies", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario())
+		var updated map[string]string
+		messages := ask(&stubCaptchaSolver{cookies: map[string]string{"_U": "other"}}, &updated)
+		last := messages[len(messages)-1]
+		assert.ErrorIs(t, last.Error, ErrCaptchaRequired)
+		assert.Contains(t, last.Text, "no cookie named cct")
+		assert.Nil(t, updated)
+	})
+	t.Run("fail fast", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario())
+		var updated map[string]string
+		messages := ask(FailFastCaptchaSolver{}, &updated)
+		last := messages[len(messages)-1]
+		assert.ErrorIs(t, last.Error, ErrCaptchaRequired)
+		assert.Contains(t, last.Text, "solving CAPTCHA is disabled")
+	})
+	t.Run("solver error", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario())
+		var updated map[string]string
+		messages := ask(&stubCaptchaSolver{err: errors.New("boom")}, &updated)
+		assert.Contains(t, messages[len(messages)-1].Text, "boom")
+	})
+}
+
+func TestCommandCaptchaSolver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	challenge := CaptchaChallenge{Cookies: map[string]string{"_U": "fake"}, Endpoints: DefaultEndpoints}
+	t.Run("cookie string", func(t *testing.T) {
+		solver := CommandCaptchaSolver{Name: "sh", Args: []string{"-c",
+			`grep -q '"cookies":"_U=fake; "' && echo 'cct=solved; MUID=new'`}}
+		cookies, err := solver.SolveCaptcha(context.Background(), challenge)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"cct": "solved", "MUID": "new"}, cookies)
+	})
+	t.Run("json", func(t *testing.T) {
+		solver := CommandCaptchaSolver{Name: "sh", Args: []string{"-c", `cat >/dev/null; echo '{"cct":"solved"}'`}}
+		cookies, err := solver.SolveCaptcha(context.Background(), challenge)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"cct": "solved"}, cookies)
+	})
+	t.Run("failure", func(t *testing.T) {
+		solver := CommandCaptchaSolver{Name: "sh", Args: []string{"-c", `echo oops >&2; exit 1`}}
+		_, err := solver.SolveCaptcha(context.Background(), challenge)
+		assert.ErrorContains(t, err, "oops")
+	})
+}
+
+func TestParseCaptchaSolver(t *testing.T) {
+	for spec, expected := range map[string]CaptchaSolver{
+		"browser":                     BrowserCaptchaSolver{},
+		"fail_fast":                   FailFastCaptchaSolver{},
+		"bypass:https://example.com/": BypassServerCaptchaSolver{URL: "https://example.com/"},
+		"command:solve --headless":    CommandCaptchaSolver{Name: "solve", Args: []string{"--headless"}},
+	} {
+		solver, err := ParseCaptchaSolver(spec)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, solver)
+	}
+	for _, spec := range []string{"", "bypass:", "command:", "unknown"} {
+		_, err := ParseCaptchaSolver(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -0,0 +1,105 @@
+package sydney
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+)
+
+// Citation styles of the answer text, which cites the sources with markers like [^1^].
+const (
+	CitationStyleNone      = ""          // keep the markers as is
+	CitationStyleStrip     = "strip"     // remove the markers
+	CitationStyleInline    = "inline"    // replace the markers with links like [[1]](https://...)
+	CitationStyleFootnotes = "footnotes" // Markdown footnotes, defined at the end of the answer
+)
+
+var CitationStyles = []string{CitationStyleNone, CitationStyleStrip, CitationStyleInline, CitationStyleFootnotes}
+
+var (
+	citationMarkerRegex = regexp.MustCompile(`\[\^(\d+)\^]|\(\^(\d+)\^\)`)
+	// citationPartialRegex matches the end of a text which may be the start of a marker
+	// completed by the next chunk.
+	citationPartialRegex = regexp.MustCompile(`(?:\[|\()(?:\^\d*\^?)?$`)
+)
+
+// updateCitations updates the sources cited by the answer from the reference footer of the
+// message, which grows while the answer is streamed.
+func (o *StreamDecoder) updateCitations(message gjson.Result, messageText string) {
+	if o.CitationStyle == CitationStyleNone {
+		return
+	}
+	if o.citations == nil {
+		o.citations = map[int]SourceAttribute{}
+	}
+	for _, source := range o.citedSources(message, messageText) {
+		o.citations[source.Index] = source
+	}
+}
+
+// cite rewrites the markers of the streamed text in the citation style. The end of the text
+// which may be part of a marker is held back until the next chunk or flushCitations.
+func (o *StreamDecoder) cite(text string) string {
+	if o.CitationStyle == CitationStyleNone {
+		return text
+	}
+	text = o.pendingCitationText + text
+	o.pendingCitationText = ""
+	if loc := citationPartialRegex.FindStringIndex(text); loc != nil {
+		o.pendingCitationText = text[loc[0]:]
+		text = text[:loc[0]]
+	}
+	return citationMarkerRegex.ReplaceAllStringFunc(text, func(marker string) string {
+		matches := citationMarkerRegex.FindStringSubmatch(marker)
+		if matches[2] != "" { // the target of a Markdown link, like [text](^1^)
+			index, _ := strconv.Atoi(matches[2])
+			if source, ok := o.citations[index]; ok {
+				return "(" + source.Link + ")"
+			}
+			return marker
+		}
+		index, _ := strconv.Atoi(matches[1])
+		switch o.CitationStyle {
+		case CitationStyleInline:
+			if source, ok := o.citations[index]; ok {
+				return "[[" + matches[1] + "]](" + source.Link + ")"
+			}
+			return "[" + matches[1] + "]"
+		case CitationStyleFootnotes:
+			if !slices.Contains(o.citedIndexes, index) {
+				o.citedIndexes = append(o.citedIndexes, index)
+			}
+			return "[^" + matches[1] + "]"
+		}
+		return ""
+	})
+}
+
+// flushCitations returns the text held back by cite. If the answer is over, the footnotes
+// are also defined for the footnotes style.
+func (o *StreamDecoder) flushCitations(answerOver bool) []Message {
+	text := o.pendingCitationText
+	o.pendingCitationText = ""
+	if answerOver && o.CitationStyle == CitationStyleFootnotes {
+		slices.Sort(o.citedIndexes)
+		footnotes := lo.FilterMap(o.citedIndexes, func(index int, _ int) (string, bool) {
+			source, ok := o.citations[index]
+			return "[^" + strconv.Itoa(index) + "]: [" + source.Title + "](" + source.Link + ")", ok
+		})
+		o.citedIndexes = nil
+		if len(footnotes) != 0 {
+			text += "\n\n" + strings.Join(footnotes, "\n")
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return []Message{{
+		Type: MessageTypeMessageText,
+		Text: text,
+	}}
+}
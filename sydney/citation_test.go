@@ -0,0 +1,51 @@
+package sydney
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCitationStyle(t *testing.T) {
+	footer := `[1]: https://example.com \"\"\n[2]: https://another.com \"\"\n\n`
+	frames := []string{
+		`{"type":1,"arguments":[{"messages":[{"messageType":"InternalSearchResult","text":"[{\"web_search_results\":[{\"title\":\"Example\",\"url\":\"https://example.com\"},{\"title\":\"Another\",\"url\":\"https://another.com\"}]}]"}]}]}`,
+		`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"News[^1","adaptiveCards":[{"body":[{"type":"TextBlock","text":"` + footer + `News[^1"}]}]}]}]}`,
+		`{"type":1,"arguments":[{"messages":[{"text":"News[^1^] and [more](^2^)[^2^][","adaptiveCards":[{"body":[{"type":"TextBlock","text":"` + footer + `News[^1^] and [more](^2^)[^2^]["}]}]}]}]}`,
+		`{"type":1,"arguments":[{"messages":[{"text":"News[^1^] and [more](^2^)[^2^][^3^].","adaptiveCards":[{"body":[{"type":"TextBlock","text":"` + footer + `News[^1^] and [more](^2^)[^2^][^3^]."}]}]}]}]}`,
+		`{"type":2,"invocationId":"0","item":{"result":{"value":"Success"}}}`,
+	}
+	answer := func(style string) string {
+		decoder := NewStreamDecoder("news")
+		decoder.CitationStyle = style
+		return strings.Join(lo.FilterMap(decodeAll(decoder, frames...), func(msg Message, index int) (string, bool) {
+			return msg.Text, msg.Type == MessageTypeMessageText
+		}), "")
+	}
+	t.Run("none", func(t *testing.T) {
+		assert.Equal(t, "News[^1^] and [more](^2^)[^2^][^3^].", answer(CitationStyleNone))
+	})
+	t.Run("strip", func(t *testing.T) {
+		assert.Equal(t, "News and [more](https://another.com).", answer(CitationStyleStrip))
+	})
+	t.Run("inline", func(t *testing.T) {
+		assert.Equal(t, "News[[1]](https://example.com) and [more](https://another.com)"+
+			"[[2]](https://another.com)[3].", answer(CitationStyleInline))
+	})
+	t.Run("footnotes", func(t *testing.T) {
+		assert.Equal(t, "News[^1] and [more](https://another.com)[^2][^3].\n\n"+
+			"[^1]: [Example](https://example.com)\n[^2]: [Another](https://another.com)", answer(CitationStyleFootnotes))
+	})
+	t.Run("text held back at the end", func(t *testing.T) {
+		decoder := NewStreamDecoder("hi")
+		decoder.CitationStyle = CitationStyleStrip
+		messages := decodeAll(decoder,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"Hi (^"}]}]}`,
+			`{"type":2,"invocationId":"0","item":{"result":{"value":"Success"}}}`,
+		)
+		assert.Equal(t, []Message{{Type: MessageTypeMessageText, Text: "Hi "}, {Type: MessageTypeMessageText, Text: "(^"}},
+			messages)
+	})
+}
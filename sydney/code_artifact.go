@@ -0,0 +1,127 @@
+package sydney
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+)
+
+// CodeArtifact is one run of the code interpreter: the code Bing wrote and what running it
+// produced.
+type CodeArtifact struct {
+	Language string   `json:"language"`
+	Source   string   `json:"source"`
+	Stdout   string   `json:"stdout"`
+	Images   []string `json:"images"` // URLs of the produced images, e.g. charts
+	Files    []string `json:"files"`  // URLs of the produced files
+}
+
+// codeArtifactCollector collects the frames of one code interpreter run.
+type codeArtifactCollector struct {
+	artifact  CodeArtifact
+	codeID    string            // id of the GeneratedCode message
+	outputIDs []string          // ids of the output messages, in order
+	outputs   map[string]string // the latest text of each output message, which grows
+}
+
+func isCodeInterpreterMessage(message gjson.Result) bool {
+	switch message.Get("messageType").String() {
+	case "GeneratedCode":
+		return true
+	case "Progress":
+		return message.Get("contentOrigin").String() == "CodeInterpreter"
+	}
+	return false
+}
+
+// startCodeArtifact finishes the current run, if any, and starts a new one with the code.
+// Updates of the code of the current run only replace it.
+func (o *StreamDecoder) startCodeArtifact(message gjson.Result) []Message {
+	id := message.Get("messageId").String()
+	if o.codeArtifact != nil && id != "" && o.codeArtifact.codeID == id {
+		o.codeArtifact.artifact.Source = message.Get("text").String()
+		return nil
+	}
+	out := o.finishCodeArtifact()
+	o.codeArtifact = &codeArtifactCollector{
+		artifact: CodeArtifact{
+			Language: lo.Ternary(message.Get("language").String() == "",
+				"python", message.Get("language").String()),
+			Source: message.Get("text").String(),
+		},
+		codeID:  id,
+		outputs: map[string]string{},
+	}
+	return out
+}
+
+// collectCodeOutput adds the output of running the code to the current run.
+func (o *StreamDecoder) collectCodeOutput(message gjson.Result) {
+	if o.codeArtifact == nil {
+		o.codeArtifact = &codeArtifactCollector{
+			artifact: CodeArtifact{Language: "python"},
+			outputs:  map[string]string{},
+		}
+	}
+	collector := o.codeArtifact
+	if text := message.Get("text").String(); text != "" {
+		id := message.Get("messageId").String()
+		if _, ok := collector.outputs[id]; !ok {
+			collector.outputIDs = append(collector.outputIDs, id)
+		}
+		collector.outputs[id] = text
+	}
+	walkAdaptiveCards(message.Get("adaptiveCards"), func(element gjson.Result) {
+		switch element.Get("type").String() {
+		case "Image":
+			collector.artifact.Images = append(collector.artifact.Images, element.Get("url").String())
+		case "Action.OpenUrl":
+			collector.artifact.Files = append(collector.artifact.Files, element.Get("url").String())
+		}
+	})
+}
+
+// finishCodeArtifact returns the current run as a message, if any.
+func (o *StreamDecoder) finishCodeArtifact() []Message {
+	if o.codeArtifact == nil {
+		return nil
+	}
+	collector := o.codeArtifact
+	o.codeArtifact = nil
+	artifact := collector.artifact
+	artifact.Stdout = strings.Join(lo.Map(collector.outputIDs, func(id string, index int) string {
+		return collector.outputs[id]
+	}), "\n")
+	artifact.Images = lo.Uniq(lo.Compact(artifact.Images))
+	artifact.Files = lo.Uniq(lo.Compact(artifact.Files))
+	v, _ := json.Marshal(&artifact)
+	return []Message{{
+		Type:         MessageTypeCodeArtifact,
+		Text:         string(v),
+		CodeArtifact: &artifact,
+	}}
+}
+
+// walkAdaptiveCards calls fn with every element and action of the adaptive cards.
+func walkAdaptiveCards(value gjson.Result, fn func(element gjson.Result)) {
+	if value.IsArray() {
+		for _, item := range value.Array() {
+			walkAdaptiveCards(item, fn)
+		}
+		return
+	}
+	if !value.IsObject() {
+		return
+	}
+	if value.Get("type").Exists() {
+		fn(value)
+	}
+	value.ForEach(func(key, child gjson.Result) bool {
+		if child.IsArray() || child.IsObject() {
+			walkAdaptiveCards(child, fn)
+		}
+		return true
+	})
+}
@@ -1,31 +1,35 @@
 package sydney
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"log/slog"
-	"strconv"
 	"strings"
 	"sydneyqt/util"
 	"time"
 )
 
-func (o *Sydney) createConversation() (CreateConversationResponse, error) {
+func (o *Sydney) createConversation(ctx context.Context) (CreateConversationResponse, error) {
+	return retry(ctx, o.retryPolicy, "create conversation", func() (CreateConversationResponse, error) {
+		return o.createConversationOnce(ctx)
+	})
+}
+
+func (o *Sydney) createConversationOnce(ctx context.Context) (CreateConversationResponse, error) {
 	var empty CreateConversationResponse
-	_, client, err := util.MakeHTTPClient(o.proxy, 10*time.Second)
+	_, client, err := o.makeHTTPClient(10 * time.Second)
 	if err != nil {
 		return empty, err
 	}
-	resp, err := client.R().SetHeader("Accept", "application/json").
-		SetHeader("Cookie", util.FormatCookieString(o.cookies)).Get(o.createConversationURL)
+	resp, err := client.R().SetContext(ctx).SetHeader("Accept", "application/json").
+		SetHeader("Cookie", o.cookieString()).Get(o.endpoints.CreateConversation)
 	if err != nil {
 		return empty, err
 	}
 	bodyV := resp.Bytes()
 	if resp.GetStatusCode() != 200 {
 		slog.Error("Failed body", "v", string(bodyV))
-		return empty, errors.New("failed to create the conversation, code: " +
-			strconv.Itoa(resp.StatusCode) + "; please check your proxy settings and your account")
+		return empty, newConversationCreateError(resp.StatusCode, "", "")
 	}
 	var response CreateConversationResponse
 	err = json.Unmarshal(bodyV, &response)
@@ -33,7 +37,7 @@ func (o *Sydney) createConversation() (CreateConversationResponse, error) {
 		return empty, err
 	}
 	if response.Result.Value != "Success" {
-		return empty, errors.New("failed to create the conversation: message: " + response.Result.Message)
+		return empty, newConversationCreateError(0, response.Result.Value, response.Result.Message)
 	}
 	if value := resp.Header.Get("X-Sydney-Encryptedconversationsignature"); value != "" {
 		response.SecAccessToken = value
@@ -46,8 +50,10 @@ func (o *Sydney) createConversation() (CreateConversationResponse, error) {
 		cookieFields = append(cookieFields, strings.Split(field, ";")[0])
 	}
 	newCookies := util.ParseCookiesFromString(strings.Join(cookieFields, "; "))
-	slog.Info("Cookies to update when creating conversation", "diff", newCookies)
-	o.UpdateModifiedCookies(newCookies)
+	if len(newCookies) != 0 {
+		slog.Info("Cookies to update when creating conversation", "diff", newCookies)
+		o.UpdateModifiedCookies(newCookies)
+	}
 	slog.Debug("Create conversation", "response", response)
 	slog.Info("Created Conversation")
 	return response, nil
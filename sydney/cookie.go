@@ -1,6 +1,7 @@
 package sydney
 
 import (
+	"context"
 	"errors"
 	"regexp"
 	"strconv"
@@ -9,10 +10,6 @@ import (
 )
 
 func (o *Sydney) GetUser() (string, error) {
-	_, client, err := util.MakeHTTPClient(o.proxy, 15*time.Second)
-	if err != nil {
-		return "", err
-	}
 	cookies, err := util.ReadCookiesFile()
 	if err != nil {
 		return "", err
@@ -20,9 +17,19 @@ func (o *Sydney) GetUser() (string, error) {
 	if len(cookies) == 0 {
 		return "", errors.New("cookie file is empty")
 	}
+	return o.getUser(context.Background(), util.FormatCookieString(cookies))
+}
+
+// getUser returns the name of the user signed in with the cookies.
+func (o *Sydney) getUser(ctx context.Context, cookies string) (string, error) {
+	_, client, err := o.makeHTTPClient(15 * time.Second)
+	if err != nil {
+		return "", err
+	}
 	resp, err := client.R().
-		SetHeader("Cookie", util.FormatCookieString(cookies)).
-		Get("https://www.bing.com/search?q=Bing+AI&showconv=1")
+		SetContext(ctx).
+		SetHeader("Cookie", cookies).
+		Get(o.endpoints.GetUser)
 	if err != nil {
 		return "", err
 	}
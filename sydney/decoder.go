@@ -0,0 +1,366 @@
+package sydney
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+)
+
+// StreamDecoder converts raw ChatHub frames into messages. It keeps the state shared between
+// frames of one answer, such as the offset of the text already written and the collected
+// sources, and does no networking, so it can be fed with frames from any transport.
+type StreamDecoder struct {
+	EmitRaw       bool   // decode unhandled messages as MessageTypeRaw instead of dropping them
+	CitationStyle string // rewrite the citation markers of the text, e.g. CitationStyleFootnotes
+
+	prompt                   string // only used for logging
+	endpoints                Endpoints
+	wrote                    int
+	sourceAttributes         []SourceAttribute
+	tmpLastDocLoadingMessage string   // for removing duplicate doc loading messages
+	resuming                 bool     // skip the text written before reconnecting
+	searchQueries            []string // the emitted search queries, not emitted again when resuming
+	codeArtifact             *codeArtifactCollector
+	citations                map[int]SourceAttribute // the cited sources by index
+	citedIndexes             []int                   // for defining the footnotes
+	pendingCitationText      string                  // the end of the text which may be part of a marker
+	finished                 bool
+}
+
+func NewStreamDecoder(prompt string) *StreamDecoder {
+	return &StreamDecoder{prompt: prompt, endpoints: DefaultEndpoints}
+}
+
+// Finished reports whether the answer has come to an end because of an error or a revoke.
+// Frames decoded afterward are ignored.
+func (o *StreamDecoder) Finished() bool {
+	return o.finished
+}
+
+// Decode decodes one raw message and returns the messages it yields, which may be empty.
+func (o *StreamDecoder) Decode(msg RawMessage) []Message {
+	if o.finished {
+		return nil
+	}
+	if msg.Error != nil {
+		o.finished = true
+		return append(append(o.finishCodeArtifact(), o.flushCitations(false)...), Message{
+			Type:  MessageTypeError,
+			Text:  msg.Error.Error(),
+			Error: msg.Error,
+		})
+	}
+	if msg.Reconnecting {
+		o.resuming = true
+		return []Message{{
+			Type: MessageTypeReconnecting,
+			Text: "The connection was lost. Reconnecting to resume the answer...",
+		}}
+	}
+	var out []Message
+	data := gjson.Parse(msg.Data)
+	if data.Get("type").Int() == 1 && data.Get("arguments.0.messages").Exists() {
+		message := data.Get("arguments.0.messages.0")
+		if !isCodeInterpreterMessage(message) {
+			out = o.finishCodeArtifact()
+		}
+		out = append(out, o.decodeUpdate(data, message)...)
+	} else if data.Get("type").Int() == 2 {
+		out = append(o.finishCodeArtifact(), o.flushCitations(true)...)
+		if data.Get("item.throttling").Exists() {
+			out = append(out, throttling(data.Get("item.throttling"))...)
+		}
+		if data.Get("item.messages").Exists() {
+			out = append(out, adaptiveCards(data.Get("item.messages"))...)
+			message := data.Get("item.messages|@reverse|0")
+			out = append(out, suggestedResponses(message)...)
+		}
+	}
+	return out
+}
+
+func (o *StreamDecoder) decodeUpdate(data gjson.Result, message gjson.Result) []Message {
+	msgType := message.Get("messageType")
+	messageText := message.Get("text").String()
+	messageHiddenText := message.Get("hiddenText").String()
+	contentOrigin := message.Get("contentOrigin").String()
+	switch msgType.String() {
+	case "InternalSearchQuery":
+		if o.resuming && slices.Contains(o.searchQueries, messageText) {
+			return nil
+		}
+		o.searchQueries = append(o.searchQueries, messageText)
+		return []Message{{
+			Type: MessageTypeSearchQuery,
+			Text: messageText,
+		}}
+	case "InternalSearchResult":
+		if strings.Contains(messageHiddenText,
+			"Web search returned no relevant result") {
+			slog.Info("Web search returned no relevant result")
+			return nil
+		}
+		if !gjson.Valid(messageText) {
+			slog.Error("Error when parsing InternalSearchResult", "messageText", messageText)
+			return nil
+		}
+		arr := gjson.Parse(messageText).Array()
+		for _, group := range arr {
+			group.ForEach(func(key, value gjson.Result) bool {
+				for _, subGroup := range value.Array() {
+					link := subGroup.Get("url").String()
+					if lo.ContainsBy(o.sourceAttributes, func(source SourceAttribute) bool {
+						return source.Link == link
+					}) { // sent again when resuming
+						continue
+					}
+					o.sourceAttributes = append(o.sourceAttributes, SourceAttribute{
+						Link:  link,
+						Title: subGroup.Get("title").String(),
+					})
+				}
+				return true
+			})
+		}
+	case "InternalLoaderMessage":
+		if contentOrigin == "retrieve-shortdoc-progress" || contentOrigin == "compress-longdoc-progress" {
+			docLoadingMessage := messageText + " " + messageHiddenText + " (" + contentOrigin + ")"
+			if o.tmpLastDocLoadingMessage == docLoadingMessage { // message is duplicate
+				return nil
+			}
+			o.tmpLastDocLoadingMessage = docLoadingMessage
+			return []Message{{
+				Type: MessageTypeLoading,
+				Text: docLoadingMessage,
+			}}
+		}
+		if message.Get("hiddenText").Exists() {
+			return []Message{{
+				Type: MessageTypeLoading,
+				Text: messageHiddenText,
+			}}
+		}
+		if message.Get("text").Exists() {
+			return []Message{{
+				Type: MessageTypeLoading,
+				Text: messageText,
+			}}
+		}
+		return []Message{{
+			Type: MessageTypeLoading,
+			Text: message.Raw,
+		}}
+	case "GenerateContentQuery":
+		switch message.Get("contentType").String() {
+		case "IMAGE":
+			generativeImage := GenerativeImage{
+				Text: messageText,
+				URL: o.endpoints.ImageCreate + "?" +
+					"partner=sydney&re=1&showselective=1&sude=1&kseed=7500&SFX=2&gptexp=unknown" +
+					"&q=" + url.QueryEscape(messageText) + "&iframeid=" +
+					message.Get("messageId").String(),
+			}
+			v, err := json.Marshal(&generativeImage)
+			if err != nil {
+				util.GracefulPanic(err)
+			}
+			return []Message{{
+				Type:  MessageTypeGenerativeImage,
+				Text:  string(v),
+				Image: &generativeImage,
+			}}
+		case "SUNO":
+			generativeMusic := GenerativeMusic{
+				IFrameID:  message.Get("messageId").String(),
+				RequestID: strings.TrimPrefix(messageHiddenText, "RequestId="),
+				Text:      message.Get("invocation").String(),
+			}
+			v, err := json.Marshal(&generativeMusic)
+			if err != nil {
+				util.GracefulPanic(err)
+			}
+			return []Message{{
+				Type:  MessageTypeGenerativeMusic,
+				Text:  string(v),
+				Music: &generativeMusic,
+			}}
+		default:
+			slog.Warn("Unsupported content type",
+				"contentType", message.Get("contentType").String(),
+				"triggered-by", o.prompt, "response", message.Raw)
+			return o.raw(message)
+		}
+	case "Progress":
+		switch contentOrigin {
+		case "CodeInterpreter":
+			invocation := message.Get("invocation").String()
+			if invocation == "" {
+				o.collectCodeOutput(message)
+				return nil
+			}
+			return []Message{{
+				Type: MessageTypeExecutingTask,
+				Text: invocation,
+			}}
+		case "OpenAPI-spec":
+			text := message.Get("adaptiveCards.0.body.0.columns.0.items.0.text").String()
+			if text == "" {
+				return nil
+			}
+			return []Message{{
+				Type: MessageTypeOpenAPICall,
+				Text: text,
+			}}
+		default:
+			slog.Warn("Unsupported progress type",
+				"contentOrigin", contentOrigin,
+				"triggered-by", o.prompt, "response", message.Raw)
+			return o.raw(message)
+		}
+	case "GeneratedCode":
+		return append(o.startCodeArtifact(message), Message{
+			Type: MessageTypeGeneratedCode,
+			Text: messageText,
+		})
+	case "":
+		var out []Message
+		if data.Get("arguments.0.cursor").Exists() && !o.resuming { // a new text block
+			o.wrote = 0
+			out = append(o.flushCitations(false), o.searchResult(message, messageText)...)
+		}
+		o.updateCitations(message, messageText)
+		if contentOrigin == "Apology" {
+			o.finished = true
+			out = append(out, o.flushCitations(false)...)
+			if o.wrote != 0 {
+				return append(out, Message{
+					Type:  MessageTypeError,
+					Text:  "Message revoke detected",
+					Error: ErrMessageRevoke,
+				})
+			}
+			return append(out, Message{
+				Type:  MessageTypeError,
+				Text:  "Looks like the user's message has triggered the Bing filter",
+				Error: ErrMessageFiltered,
+			})
+		}
+		if o.wrote < len(messageText) {
+			if text := o.cite(messageText[o.wrote:]); text != "" {
+				out = append(out, Message{
+					Type: MessageTypeMessageText,
+					Text: text,
+				})
+			}
+			o.wrote = len(messageText)
+			o.resuming = false
+		} else if o.wrote > len(messageText) && !o.resuming { // Bing deletes some already sent text
+			o.wrote = len(messageText)
+		}
+		return append(out, suggestedResponses(message)...)
+	default:
+		slog.Warn("Unsupported message type",
+			"type", msgType.String(), "triggered-by", o.prompt, "response", message.Raw)
+		return o.raw(message)
+	}
+	return nil
+}
+
+// raw returns the unhandled message as is if EmitRaw is set.
+func (o *StreamDecoder) raw(message gjson.Result) []Message {
+	if !o.EmitRaw {
+		return nil
+	}
+	return []Message{{
+		Type: MessageTypeRaw,
+		Text: message.Raw,
+	}}
+}
+
+// searchResult extracts search results from the text block of the adaptive card.
+func (o *StreamDecoder) searchResult(message gjson.Result, messageText string) []Message {
+	resultSources := o.citedSources(message, messageText)
+	var resultArr []string
+	for _, src := range resultSources {
+		v, _ := json.Marshal(&src)
+		resultArr = append(resultArr, "  "+string(v))
+	}
+	if len(resultArr) == 0 {
+		return nil
+	}
+	return []Message{{
+		Type:    MessageTypeSearchResult,
+		Text:    "[\n" + strings.Join(resultArr, ",\n") + "\n]",
+		Sources: resultSources,
+	}}
+}
+
+// citedSources returns the sources in the reference footer of the text block of the adaptive
+// card, numbered as they are cited in the text.
+func (o *StreamDecoder) citedSources(message gjson.Result, messageText string) []SourceAttribute {
+	text := strings.TrimSuffix(message.Get("adaptiveCards.0.body.0.text").String(), messageText)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	arr := lo.Filter(lo.Map(strings.Split(text, "\n"), func(item string, index int) string {
+		return strings.Trim(item, " \"")
+	}), func(item string, index int) bool {
+		return item != ""
+	})
+	re := regexp.MustCompile(`\[(\d+)]: (.*)`)
+	var resultSources []SourceAttribute
+	for _, line := range arr {
+		matches := re.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			continue
+		}
+		ix := matches[1]
+		link := matches[2]
+		sourceAttribute, ok := lo.Find(o.sourceAttributes, func(item SourceAttribute) bool {
+			return item.Link == link
+		})
+		if !ok {
+			continue
+		}
+		sourceAttribute.Index, _ = strconv.Atoi(ix)
+		resultSources = append(resultSources, sourceAttribute)
+	}
+	return resultSources
+}
+
+func suggestedResponses(message gjson.Result) []Message {
+	if !message.Get("suggestedResponses").Exists() {
+		return nil
+	}
+	arr := util.Map(message.Get("suggestedResponses").Array(), func(v gjson.Result) string {
+		return v.Get("text").String()
+	})
+	v, _ := json.Marshal(arr)
+	return []Message{{
+		Type:        MessageTypeSuggestedResponses,
+		Text:        string(v),
+		Suggestions: arr,
+	}}
+}
+
+func throttling(value gjson.Result) []Message {
+	var throttling Throttling
+	if err := json.Unmarshal([]byte(value.Raw), &throttling); err != nil {
+		slog.Error("Error when parsing throttling", "throttling", value.Raw, "err", err)
+		return nil
+	}
+	v, _ := json.Marshal(&throttling)
+	return []Message{{
+		Type:       MessageTypeThrottling,
+		Text:       string(v),
+		Throttling: &throttling,
+	}}
+}
@@ -0,0 +1,197 @@
+package sydney
+
+import (
+	"errors"
+	"sydneyqt/util"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeAll(decoder *StreamDecoder, frames ...string) []Message {
+	var result []Message
+	for _, frame := range frames {
+		result = append(result, decoder.Decode(RawMessage{Data: frame})...)
+	}
+	return result
+}
+
+func TestStreamDecoder(t *testing.T) {
+	t.Run("streaming text", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":1,"target":"update","arguments":[{"cursor":{"j":"$['a7613b58-d7f3-4d4b-a4d2-2c1a5d6f4bd8'].adaptiveCards[0].body[0].text","p":-1},"messages":[{"text":"Hello","author":"bot"}]}]}`,
+			`{"type":1,"target":"update","arguments":[{"messages":[{"text":"Hello, world","author":"bot"}]}]}`,
+			`{"type":1,"target":"update","arguments":[{"messages":[{"text":"Hello, world","author":"bot"}]}]}`,
+			`{"type":1,"target":"update","arguments":[{"messages":[{"text":"Hello, world!","author":"bot"}]}]}`,
+		)
+		assert.Equal(t, []Message{
+			{Type: MessageTypeMessageText, Text: "Hello"},
+			{Type: MessageTypeMessageText, Text: ", world"},
+			{Type: MessageTypeMessageText, Text: "!"},
+		}, messages)
+	})
+	t.Run("cursor resets the written offset", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"First"}]}]}`,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"Second"}]}]}`,
+		)
+		assert.Equal(t, []Message{
+			{Type: MessageTypeMessageText, Text: "First"},
+			{Type: MessageTypeMessageText, Text: "Second"},
+		}, messages)
+	})
+	t.Run("search results", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("news"),
+			`{"type":1,"arguments":[{"messages":[{"messageType":"InternalSearchQuery","text":"Searching the web for: `+"`news`"+`"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"InternalSearchResult","hiddenText":"","text":"[{\"web_search_results\":[{\"title\":\"Example\",\"url\":\"https://example.com\"},{\"title\":\"Another\",\"url\":\"https://another.com\"}]}]"}]}]}`,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"News[^1^]","adaptiveCards":[{"body":[{"type":"TextBlock","text":"[1]: https://example.com \"\"\nNews[^1^]"}]}]}]}]}`,
+		)
+		assert.Equal(t, []Message{
+			{Type: MessageTypeSearchQuery, Text: "Searching the web for: `news`"},
+			{
+				Type: MessageTypeSearchResult,
+				Text: "[\n  {\"index\":1,\"link\":\"https://example.com\",\"title\":\"Example\"}\n]",
+				Sources: []SourceAttribute{
+					{Index: 1, Link: "https://example.com", Title: "Example"},
+				},
+			},
+			{Type: MessageTypeMessageText, Text: "News[^1^]"},
+		}, messages)
+	})
+	t.Run("resuming", func(t *testing.T) {
+		decoder := NewStreamDecoder("news")
+		decoder.CitationStyle = CitationStyleInline
+		frames := []string{
+			`{"type":1,"arguments":[{"messages":[{"messageType":"InternalSearchQuery","text":"Searching the web for: ` + "`news`" + `"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"InternalSearchResult","hiddenText":"","text":"[{\"web_search_results\":[{\"title\":\"Example\",\"url\":\"https://example.com\"}]}]"}]}]}`,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"News[^1^]","adaptiveCards":[{"body":[{"type":"TextBlock","text":"[1]: https://example.com \"\"\nNews[^1^]"}]}]}]}]}`,
+		}
+		messages := decodeAll(decoder, frames...)
+		messages = append(messages, decoder.Decode(RawMessage{Reconnecting: true})...)
+		// Bing streams the answer again from the start on the new connection
+		messages = append(messages, decodeAll(decoder, append(frames,
+			`{"type":1,"arguments":[{"messages":[{"text":"News[^1^] today.","adaptiveCards":[{"body":[{"type":"TextBlock","text":"[1]: https://example.com \"\"\nNews[^1^] today."}]}]}]}]}`)...)...)
+		assert.Equal(t, []string{MessageTypeSearchQuery, MessageTypeSearchResult, MessageTypeMessageText,
+			MessageTypeReconnecting, MessageTypeMessageText}, lo.Map(messages, func(msg Message, _ int) string {
+			return msg.Type
+		}))
+		assert.Equal(t, "News[[1]](https://example.com) today.", messageText(messages))
+		assert.Len(t, decoder.sourceAttributes, 1)
+	})
+	t.Run("message revoke", func(t *testing.T) {
+		decoder := NewStreamDecoder("hi")
+		messages := decodeAll(decoder,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"Something"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"text":"Sorry","contentOrigin":"Apology"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"text":"ignored"}]}]}`,
+		)
+		assert.Len(t, messages, 2)
+		assert.True(t, errors.Is(messages[1].Error, ErrMessageRevoke))
+		assert.True(t, decoder.Finished())
+	})
+	t.Run("message filtered", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":1,"arguments":[{"messages":[{"text":"Sorry","contentOrigin":"Apology"}]}]}`,
+		)
+		assert.Len(t, messages, 1)
+		assert.True(t, errors.Is(messages[0].Error, ErrMessageFiltered))
+	})
+	t.Run("suggested responses from the final message", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":2,"invocationId":"0","item":{"messages":[{"text":"hi","author":"user"},{"text":"Hello","author":"bot","suggestedResponses":[{"text":"How are you?"},{"text":"Tell me a joke."}]}],"result":{"value":"Success"}}}`,
+		)
+		assert.Equal(t, []Message{
+			{
+				Type:        MessageTypeSuggestedResponses,
+				Text:        `["How are you?","Tell me a joke."]`,
+				Suggestions: []string{"How are you?", "Tell me a joke."},
+			},
+		}, messages)
+	})
+	t.Run("adaptive cards from the final message", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":2,"invocationId":"0","item":{"messages":[{"text":"hi","author":"user"},{"text":"Hello","author":"bot","adaptiveCards":[{"type":"AdaptiveCard","body":[{"type":"TextBlock","text":"[1]: https://example.com \"\"\n\nHello"},{"type":"Image","url":"https://example.com/a.png"},{"type":"TextBlock","text":"Learn more: [1. example.com](https://example.com)","size":"small"}]}]},{"messageType":"InternalSearchQuery","text":"hello","author":"bot"}],"result":{"value":"Success"}}}`,
+			`{"type":2,"invocationId":"0","item":{"messages":[{"text":"Hello","author":"bot","adaptiveCards":[{"type":"AdaptiveCard","body":[{"type":"TextBlock","text":"Hello"}]}]}],"result":{"value":"Success"}}}`,
+		)
+		assert.Equal(t, []Message{
+			{Type: MessageTypeAdaptiveCard, Text: "![](https://example.com/a.png)"},
+		}, messages)
+	})
+	t.Run("throttling from the final message", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("hi"),
+			`{"type":2,"invocationId":"0","item":{"messages":[{"text":"hi","author":"user"},{"text":"Hello","author":"bot"}],"throttling":{"maxNumUserMessagesInConversation":30,"numUserMessagesInConversation":1,"maxNumLongDocSummaryUserMessagesInConversation":50,"numLongDocSummaryUserMessagesInConversation":0},"result":{"value":"Success"}}}`,
+		)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, MessageTypeThrottling, messages[0].Type)
+		assert.Equal(t, Throttling{
+			MaxNumUserMessagesInConversation:               30,
+			NumUserMessagesInConversation:                  1,
+			MaxNumLongDocSummaryUserMessagesInConversation: 50,
+		}, *messages[0].Throttling)
+		assert.Equal(t, 29, messages[0].Throttling.RemainingUserMessages())
+	})
+	t.Run("generative image", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("draw"),
+			`{"type":1,"arguments":[{"messages":[{"messageType":"GenerateContentQuery","contentType":"IMAGE","text":"a pigeon","messageId":"abc"}]}]}`,
+		)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, MessageTypeGenerativeImage, messages[0].Type)
+		assert.Equal(t, "a pigeon", messages[0].Image.Text)
+		assert.Contains(t, messages[0].Image.URL, "iframeid=abc")
+	})
+	t.Run("code interpreter", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("plot"),
+			`{"type":1,"arguments":[{"messages":[{"messageType":"Progress","contentOrigin":"CodeInterpreter","invocation":"Running the code"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"GeneratedCode","messageId":"code","text":"import matplotlib"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"GeneratedCode","messageId":"code","text":"import matplotlib\nprint(42)"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"Progress","contentOrigin":"CodeInterpreter","messageId":"out","text":"4"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"Progress","contentOrigin":"CodeInterpreter","messageId":"out","text":"42","adaptiveCards":[{"type":"AdaptiveCard","body":[{"type":"Image","url":"https://example.com/chart.png"}],"actions":[{"type":"Action.OpenUrl","url":"https://example.com/data.csv"}]}]}]}]}`,
+			`{"type":1,"arguments":[{"cursor":{"j":"","p":-1},"messages":[{"text":"Done"}]}]}`,
+		)
+		assert.Equal(t, []string{MessageTypeExecutingTask, MessageTypeGeneratedCode, MessageTypeGeneratedCode,
+			MessageTypeCodeArtifact, MessageTypeMessageText}, util.Map(messages, func(msg Message) string {
+			return msg.Type
+		}))
+		assert.Equal(t, CodeArtifact{
+			Language: "python",
+			Source:   "import matplotlib\nprint(42)",
+			Stdout:   "42",
+			Images:   []string{"https://example.com/chart.png"},
+			Files:    []string{"https://example.com/data.csv"},
+		}, *messages[3].CodeArtifact)
+	})
+	t.Run("code interpreter finished by the final message", func(t *testing.T) {
+		messages := decodeAll(NewStreamDecoder("run"),
+			`{"type":1,"arguments":[{"messages":[{"messageType":"GeneratedCode","messageId":"a","text":"print(1)"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"GeneratedCode","messageId":"b","text":"print(2)"}]}]}`,
+			`{"type":2,"invocationId":"0","item":{"result":{"value":"Success"}}}`,
+		)
+		artifacts := lo.Filter(messages, func(msg Message, index int) bool {
+			return msg.Type == MessageTypeCodeArtifact
+		})
+		assert.Len(t, artifacts, 2)
+		assert.Equal(t, "print(1)", artifacts[0].CodeArtifact.Source)
+		assert.Equal(t, "print(2)", artifacts[1].CodeArtifact.Source)
+	})
+	t.Run("unhandled messages", func(t *testing.T) {
+		frames := []string{
+			`{"type":1,"arguments":[{"messages":[{"messageType":"NewFeature","text":"something"}]}]}`,
+			`{"type":1,"arguments":[{"messages":[{"messageType":"Progress","contentOrigin":"NewOrigin","text":"working"}]}]}`,
+		}
+		assert.Empty(t, decodeAll(NewStreamDecoder("hi"), frames...))
+		decoder := NewStreamDecoder("hi")
+		decoder.EmitRaw = true
+		assert.Equal(t, []Message{
+			{Type: MessageTypeRaw, Text: `{"messageType":"NewFeature","text":"something"}`},
+			{Type: MessageTypeRaw, Text: `{"messageType":"Progress","contentOrigin":"NewOrigin","text":"working"}`},
+		}, decodeAll(decoder, frames...))
+	})
+	t.Run("raw error", func(t *testing.T) {
+		decoder := NewStreamDecoder("hi")
+		messages := decoder.Decode(RawMessage{Error: errors.New("boom")})
+		assert.Equal(t, MessageTypeError, messages[0].Type)
+		assert.Equal(t, "boom", messages[0].Text)
+		assert.True(t, decoder.Finished())
+	})
+}
@@ -0,0 +1,169 @@
+package sydney
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/url"
+	"strings"
+	"sydneyqt/util"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Steps of the diagnostics, in the order they are run.
+const (
+	DiagnosticStepProxy        = "proxy"
+	DiagnosticStepUser         = "user"
+	DiagnosticStepConversation = "conversation"
+	DiagnosticStepChatHub      = "chathub"
+	DiagnosticStepImageUpload  = "image_upload"
+	DiagnosticStepOpenAI       = "openai" // one for each backend, named openai:<backend name>
+)
+
+// DiagnosticsOptions configures RunDiagnostics.
+type DiagnosticsOptions struct {
+	OpenAIBackends []DiagnosticsOpenAIBackend // the OpenAI backends to check the reachability of
+}
+
+// DiagnosticsOpenAIBackend is an OpenAI backend, checked by listing its models.
+type DiagnosticsOpenAIBackend struct {
+	Name     string
+	Endpoint string
+	Key      string
+}
+
+// DiagnosticStep is the result of a step of the diagnostics.
+type DiagnosticStep struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Skipped    bool   `json:"skipped"`
+	Detail     string `json:"detail"` // what has been found if OK, or why it has been skipped
+	Error      string `json:"error"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// DiagnosticReport is the result of RunDiagnostics.
+type DiagnosticReport struct {
+	OK         bool             `json:"ok"`
+	FailedStep string           `json:"failed_step"` // the first failed step, if any
+	Steps      []DiagnosticStep `json:"steps"`
+	DurationMs int64            `json:"duration_ms"`
+}
+
+func (o *DiagnosticReport) run(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	step := DiagnosticStep{
+		Name:       name,
+		OK:         err == nil,
+		Detail:     detail,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+		if o.FailedStep == "" {
+			o.FailedStep = name
+		}
+	}
+	o.Steps = append(o.Steps, step)
+	return step.OK
+}
+
+func (o *DiagnosticReport) skip(name string, reason string) {
+	o.Steps = append(o.Steps, DiagnosticStep{Name: name, Skipped: true, Detail: reason})
+}
+
+// RunDiagnostics steps through what an answer needs to find out which part fails: resolving
+// the proxy, the cookies, creating a conversation, the ChatHub handshake, uploading an image
+// and the OpenAI backends. The steps which need the result of a failed step are skipped, and
+// the others are run anyway.
+func (o *Sydney) RunDiagnostics(ctx context.Context, options DiagnosticsOptions) DiagnosticReport {
+	start := time.Now()
+	var report DiagnosticReport
+	openAISteps := lo.Map(options.OpenAIBackends, func(backend DiagnosticsOpenAIBackend, _ int) string {
+		return DiagnosticStepOpenAI + ":" + backend.Name
+	})
+	if !report.run(DiagnosticStepProxy, func() (string, error) {
+		var routes []string
+		for _, rawURL := range []string{o.endpoints.CreateConversation, o.endpoints.ChatHub} {
+			proxy, err := util.DefaultHTTPClientFactory.ProxyURL(o.proxy, rawURL)
+			if err != nil {
+				return "", err
+			}
+			u, _ := url.Parse(proxy)
+			routes = append(routes, hostOf(rawURL)+" via "+lo.Ternary(proxy == "", "direct connection", u.Redacted()))
+		}
+		return strings.Join(routes, ", "), nil
+	}) {
+		for _, step := range append([]string{DiagnosticStepUser, DiagnosticStepConversation, DiagnosticStepChatHub,
+			DiagnosticStepImageUpload}, openAISteps...) {
+			report.skip(step, "no proxy to connect through")
+		}
+		return report.finish(start)
+	}
+	report.run(DiagnosticStepUser, func() (string, error) {
+		return o.getUser(ctx, o.cookieString())
+	})
+	var conversation CreateConversationResponse
+	if report.run(DiagnosticStepConversation, func() (string, error) {
+		var err error
+		conversation, err = o.createConversation(ctx)
+		return conversation.ConversationId, err
+	}) {
+		report.run(DiagnosticStepChatHub, func() (string, error) {
+			conn, err := o.connectChatHub(ctx, conversation)
+			if err != nil {
+				return "", err
+			}
+			conn.CloseNow()
+			return "handshake with " + hostOf(o.endpoints.ChatHub) + " finished", nil
+		})
+	} else {
+		report.skip(DiagnosticStepChatHub, "no conversation to connect to")
+	}
+	report.run(DiagnosticStepImageUpload, func() (string, error) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil); err != nil {
+			return "", err
+		}
+		return o.UploadImage(ctx, buf.Bytes())
+	})
+	for i, backend := range options.OpenAIBackends {
+		report.run(openAISteps[i], func() (string, error) {
+			client, err := util.CreateOpenAIClient(o.proxy, backend.Key, backend.Endpoint)
+			if err != nil {
+				return "", err
+			}
+			models, err := client.ListModels(ctx)
+			if err != nil {
+				return "", err
+			}
+			if len(models.Models) == 0 {
+				return "", errors.New("no models available")
+			}
+			return strings.Join(lo.Map(models.Models, func(model openai.Model, _ int) string {
+				return model.ID
+			}), ", "), nil
+		})
+	}
+	return report.finish(start)
+}
+
+func (o *DiagnosticReport) finish(start time.Time) DiagnosticReport {
+	o.OK = o.FailedStep == ""
+	o.DurationMs = time.Since(start).Milliseconds()
+	return *o
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,103 @@
+package sydney
+
+import (
+	"strings"
+)
+
+// Endpoints are the URLs of the Bing services used by Sydney. They can be pointed at a
+// self-hosted relay or a local stand-in server. Empty fields fall back to DefaultEndpoints.
+type Endpoints struct {
+	ChatHub            string `json:"chat_hub"`
+	CreateConversation string `json:"create_conversation"`
+	ImageUpload        string `json:"image_upload"`
+	ImageBlob          string `json:"image_blob"`
+	FileUpload         string `json:"file_upload"`
+	ImageCreate        string `json:"image_create"`
+	ImageCreateResults string `json:"image_create_results"`
+	MusicPage          string `json:"music_page"`
+	MusicAPI           string `json:"music_api"`
+	Thumbnail          string `json:"thumbnail"`
+	CaptchaChallenge   string `json:"captcha_challenge"`
+	CaptchaVerify      string `json:"captcha_verify"`
+	GetUser            string `json:"get_user"`
+}
+
+var DefaultEndpoints = Endpoints{
+	ChatHub:            "wss://sydney.bing.com/sydney/ChatHub",
+	CreateConversation: "https://edgeservices.bing.com/edgesvc/turing/conversation/create",
+	ImageUpload:        "https://www.bing.com/images/kblob",
+	ImageBlob:          "https://www.bing.com/images/blob",
+	FileUpload:         "https://sydney.bing.com/sydney/UploadFile",
+	ImageCreate:        "https://www.bing.com/images/create",
+	ImageCreateResults: "https://www.bing.com/images/create/async/results",
+	MusicPage:          "https://www.bing.com/videos/music",
+	MusicAPI:           "https://www.bing.com/videos/api/custom/music",
+	Thumbnail:          "https://th.bing.com/th",
+	CaptchaChallenge:   "https://www.bing.com/turing/captcha/challenge",
+	CaptchaVerify:      "https://www.bing.com/challenge/verify",
+	GetUser:            "https://www.bing.com/search?q=Bing+AI&showconv=1",
+}
+
+// LocalEndpoints returns the endpoints of a stand-in server hosting all services under baseURL,
+// with the same paths as Bing.
+func LocalEndpoints(baseURL string) Endpoints {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return Endpoints{
+		ChatHub:            wssURL(strings.Replace(strings.Replace(baseURL, "https://", "wss://", 1), "http://", "ws://", 1)),
+		CreateConversation: baseURL + "/turing/conversation/create",
+		ImageUpload:        baseURL + "/images/kblob",
+		ImageBlob:          baseURL + "/images/blob",
+		FileUpload:         baseURL + "/sydney/UploadFile",
+		ImageCreate:        baseURL + "/images/create",
+		ImageCreateResults: baseURL + "/images/create/async/results",
+		MusicPage:          baseURL + "/videos/music",
+		MusicAPI:           baseURL + "/videos/api/custom/music",
+		Thumbnail:          baseURL + "/th",
+		CaptchaChallenge:   baseURL + "/turing/captcha/challenge",
+		CaptchaVerify:      baseURL + "/challenge/verify",
+		GetUser:            baseURL + "/search?q=Bing+AI&showconv=1",
+	}
+}
+
+// endpoints merges the configured endpoints with the defaults. WssDomain and
+// CreateConversationURL are kept for compatibility and used if the matching endpoint is empty.
+func (o Options) endpoints() Endpoints {
+	endpoints := o.Endpoints
+	if endpoints.ChatHub == "" && o.WssDomain != "" {
+		endpoints.ChatHub = wssURL(o.WssDomain)
+	}
+	if endpoints.CreateConversation == "" {
+		endpoints.CreateConversation = o.CreateConversationURL
+	}
+	fill := func(pointer *string, defaultValue string) {
+		if *pointer == "" {
+			*pointer = defaultValue
+		}
+	}
+	fill(&endpoints.ChatHub, DefaultEndpoints.ChatHub)
+	fill(&endpoints.CreateConversation, DefaultEndpoints.CreateConversation)
+	fill(&endpoints.ImageUpload, DefaultEndpoints.ImageUpload)
+	fill(&endpoints.ImageBlob, DefaultEndpoints.ImageBlob)
+	fill(&endpoints.FileUpload, DefaultEndpoints.FileUpload)
+	fill(&endpoints.ImageCreate, DefaultEndpoints.ImageCreate)
+	fill(&endpoints.ImageCreateResults, DefaultEndpoints.ImageCreateResults)
+	fill(&endpoints.MusicPage, DefaultEndpoints.MusicPage)
+	fill(&endpoints.MusicAPI, DefaultEndpoints.MusicAPI)
+	fill(&endpoints.Thumbnail, DefaultEndpoints.Thumbnail)
+	fill(&endpoints.CaptchaChallenge, DefaultEndpoints.CaptchaChallenge)
+	fill(&endpoints.CaptchaVerify, DefaultEndpoints.CaptchaVerify)
+	fill(&endpoints.GetUser, DefaultEndpoints.GetUser)
+	return endpoints
+}
+
+// wssURL returns the ChatHub URL of the domain, which may also include the scheme,
+// e.g. ws://127.0.0.1:8080 for a local stand-in server.
+func wssURL(wssDomain string) string {
+	if wssDomain == "" {
+		wssDomain = "sydney.bing.com"
+	}
+	if !strings.Contains(wssDomain, "://") {
+		wssDomain = "wss://" + wssDomain
+	}
+	return strings.TrimSuffix(wssDomain, "/") + "/sydney/ChatHub"
+}
@@ -0,0 +1,122 @@
+package sydney
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+	"time"
+)
+
+// Kinds of failures, to be checked with errors.Is. Errors reported by Bing are BingError,
+// which wraps one or more of them.
+var (
+	ErrCaptchaRequired    = errors.New("captcha required")
+	ErrThrottled          = errors.New("request throttled")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrContextTooLong     = errors.New("please check if the chat context is too long")
+	ErrConversationCreate = errors.New("failed to create the conversation")
+	// ErrAnswerInterrupted is the failure of an answer whose connection has been lost and which
+	// could not be resumed on a new one.
+	ErrAnswerInterrupted = errors.New("the answer was interrupted")
+)
+
+// BingError is a failure reported by Bing, either as the result of an answer or of
+// conversation creation, or as an HTTP status code.
+type BingError struct {
+	Value      string // result value, e.g. Throttled; empty if not reported
+	Message    string // result message
+	StatusCode int    // HTTP status code; zero if not an HTTP failure
+
+	kinds  []error
+	prefix string
+}
+
+func (e *BingError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.prefix)
+	if e.StatusCode != 0 {
+		sb.WriteString(", code: " + strconv.Itoa(e.StatusCode) +
+			"; please check your proxy settings and your account")
+	}
+	if e.Value != "" {
+		sb.WriteString(": value: " + e.Value)
+	}
+	if e.Message != "" {
+		sb.WriteString(util.Ternary(e.Value != "", "; ", ": ") + "message: " + e.Message)
+	}
+	return sb.String()
+}
+
+func (e *BingError) Unwrap() []error {
+	return e.kinds
+}
+
+// Operations of the ChatHub connection which can time out.
+const (
+	TimeoutDial     = "dial"      // connecting to ChatHub, including the handshake
+	TimeoutReadIdle = "read-idle" // waiting for the next message from ChatHub
+	TimeoutWrite    = "write"     // sending a message to ChatHub
+	TimeoutTotal    = "total"     // the whole answer, including reconnecting
+)
+
+// TimeoutError is the failure of an operation of the ChatHub connection that took longer
+// than its timeout, as opposed to the connection being closed by the server.
+type TimeoutError struct {
+	Op      string        // TimeoutDial, TimeoutReadIdle, TimeoutWrite or TimeoutTotal
+	Timeout time.Duration // the timeout exceeded
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return "chathub " + e.Op + " timed out after " + e.Timeout.String()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// newStatusError returns the error of a request to Bing which failed with the status code.
+func newStatusError(operation string, statusCode int) *BingError {
+	return &BingError{
+		StatusCode: statusCode,
+		kinds:      resultKinds("", "", statusCode),
+		prefix:     "cannot " + operation,
+	}
+}
+
+// newResultError returns the error of a failed answer from the result of the final message.
+func newResultError(value string, message string) *BingError {
+	return &BingError{
+		Value:   value,
+		Message: message,
+		kinds:   resultKinds(value, message, 0),
+		prefix:  "bing explicit error",
+	}
+}
+
+// newConversationCreateError returns the error of failing to create a conversation, either
+// because of the status code or of the result value.
+func newConversationCreateError(statusCode int, value string, message string) *BingError {
+	return &BingError{
+		Value:      value,
+		Message:    message,
+		StatusCode: statusCode,
+		kinds:      append([]error{ErrConversationCreate}, resultKinds(value, message, statusCode)...),
+		prefix:     ErrConversationCreate.Error(),
+	}
+}
+
+// resultKinds returns the kinds of error of the result. A CAPTCHA is also recognized by the
+// message, as Bing doesn't always send it with the CaptchaChallenge value.
+func resultKinds(value string, message string, statusCode int) []error {
+	switch {
+	case value == "CaptchaChallenge" || strings.Contains(message, "CAPTCHA"):
+		return []error{ErrCaptchaRequired}
+	case value == "Throttled" || statusCode == 429:
+		return []error{ErrThrottled}
+	case value == "UnauthorizedRequest" || value == "Forbidden" || statusCode == 401 || statusCode == 403:
+		return []error{ErrUnauthorized}
+	}
+	return nil
+}
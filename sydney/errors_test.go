@@ -0,0 +1,78 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"sydneyqt/sydney/sydneytest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBingError(t *testing.T) {
+	t.Run("result", func(t *testing.T) {
+		err := error(newResultError("Throttled", "Request is throttled."))
+		assert.Equal(t, "bing explicit error: value: Throttled; message: Request is throttled.", err.Error())
+		assert.ErrorIs(t, err, ErrThrottled)
+		assert.NotErrorIs(t, err, ErrCaptchaRequired)
+		var bingErr *BingError
+		assert.True(t, errors.As(err, &bingErr))
+		assert.Equal(t, "Throttled", bingErr.Value)
+		assert.Equal(t, "Request is throttled.", bingErr.Message)
+	})
+	t.Run("captcha message", func(t *testing.T) {
+		assert.ErrorIs(t, newResultError("CaptchaChallenge", ""), ErrCaptchaRequired)
+		err := newResultError("InvalidSession", "User needs to solve CAPTCHA to continue.")
+		assert.ErrorIs(t, err, ErrCaptchaRequired)
+		assert.NotErrorIs(t, err, ErrThrottled)
+	})
+	t.Run("unknown result", func(t *testing.T) {
+		err := newResultError("InternalError", "")
+		assert.Equal(t, "bing explicit error: value: InternalError", err.Error())
+		for _, kind := range []error{ErrCaptchaRequired, ErrThrottled, ErrUnauthorized, ErrConversationCreate} {
+			assert.NotErrorIs(t, err, kind)
+		}
+	})
+	t.Run("conversation create", func(t *testing.T) {
+		err := newConversationCreateError(401, "", "")
+		assert.Equal(t, "failed to create the conversation, code: 401; "+
+			"please check your proxy settings and your account", err.Error())
+		assert.ErrorIs(t, err, ErrConversationCreate)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		err = newConversationCreateError(0, "UnauthorizedRequest", "Sorry, you need to login first.")
+		assert.Equal(t, "failed to create the conversation: value: UnauthorizedRequest; "+
+			"message: Sorry, you need to login first.", err.Error())
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+}
+
+func TestAskStreamErrors(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	syd := newFakeSydney(server)
+	t.Run("throttled", func(t *testing.T) {
+		server.Enqueue(sydneytest.ThrottledScenario())
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		messages := collect(ch)
+		assert.ErrorIs(t, messages[len(messages)-1].Error, ErrThrottled)
+	})
+	t.Run("captcha", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario())
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi",
+			disableCaptchaBypass: true})
+		assert.Nil(t, err)
+		messages := collect(ch)
+		assert.ErrorIs(t, messages[len(messages)-1].Error, ErrCaptchaRequired)
+	})
+	t.Run("conversation create", func(t *testing.T) {
+		server.SetCreateConversationStatus(403)
+		defer server.SetCreateConversationStatus(0)
+		_, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.ErrorIs(t, err, ErrConversationCreate)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		var bingErr *BingError
+		assert.True(t, errors.As(err, &bingErr))
+		assert.Equal(t, 403, bingErr.StatusCode)
+	})
+}
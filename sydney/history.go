@@ -0,0 +1,40 @@
+package sydney
+
+import (
+	"sydneyqt/util"
+)
+
+// previousMessagesFromHistory maps the chat history to previous messages authored by the user
+// or the bot. System messages, e.g. [system](#additional_instructions), go in as context with
+// their headers kept, since Bing has no native author for them.
+func previousMessagesFromHistory(history []util.ChatMessage) []PreviousMessage {
+	var result []PreviousMessage
+	for _, msg := range history {
+		switch {
+		case msg.Role == "system":
+			result = append(result, PreviousMessage{
+				Author:      "user",
+				Description: "[system](#" + msg.Type + ")\n" + msg.Content,
+				ContextType: "WebPage",
+				MessageType: "Context",
+			})
+		case msg.Role == "assistant" && msg.Type == MessageTypeLoading:
+			// progress of the answer, no part of the conversation
+		case msg.Role == "assistant" && msg.Type == MessageTypeSearchQuery:
+			result = append(result, PreviousMessage{Author: "bot", Text: msg.Content, MessageType: "InternalSearchQuery"})
+		case msg.Role == "assistant" && msg.Type == MessageTypeSearchResult:
+			result = append(result, PreviousMessage{Author: "bot", Text: msg.Content, MessageType: "InternalSearchResult"})
+		case msg.Role == "user" || msg.Role == "assistant":
+			text := msg.Content
+			if msg.Type != MessageTypeMessageText {
+				text = "# " + msg.Type + "\n" + text
+			}
+			result = append(result, PreviousMessage{
+				Author:      util.Ternary(msg.Role == "user", "user", "bot"),
+				Text:        text,
+				MessageType: "Chat",
+			})
+		}
+	}
+	return result
+}
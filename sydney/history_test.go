@@ -0,0 +1,49 @@
+package sydney
+
+import (
+	"context"
+	"sydneyqt/sydney/sydneytest"
+	"sydneyqt/util"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestPreviousMessagesFromHistory(t *testing.T) {
+	history := util.GetChatMessage("[system](#additional_instructions)\nBe nice.\n\n" +
+		"[user](#message)\nWhat's new?\n\n" +
+		"[assistant](#search_query)\nnews\n\n" +
+		"[assistant](#loading)\nGenerating answers for you...\n\n" +
+		"[assistant](#message)\nNothing.\n\n" +
+		"[user](#webpage_context)\nsome page")
+	assert.Equal(t, []PreviousMessage{
+		{Author: "user", Description: "[system](#additional_instructions)\nBe nice.", ContextType: "WebPage", MessageType: "Context"},
+		{Author: "user", Text: "What's new?", MessageType: "Chat"},
+		{Author: "bot", Text: "news", MessageType: "InternalSearchQuery"},
+		{Author: "bot", Text: "Nothing.", MessageType: "Chat"},
+		{Author: "user", Text: "# webpage_context\nsome page", MessageType: "Chat"},
+	}, previousMessagesFromHistory(history))
+}
+
+func TestAskStreamHistory(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	ch, err := newFakeSydney(server).AskStream(AskStreamOptions{
+		StopCtx:        context.Background(),
+		Prompt:         "And now?",
+		WebpageContext: "ignored",
+		History: []util.ChatMessage{
+			{Role: "user", Type: "message", Content: "Hi"},
+			{Role: "assistant", Type: "message", Content: "Hello"},
+		},
+	})
+	assert.Nil(t, err)
+	collect(ch)
+	previousMessages := gjson.Parse(server.ChatRequests()[0]).Get("arguments.0.previousMessages").Array()
+	assert.Len(t, previousMessages, 2)
+	assert.Equal(t, "user", previousMessages[0].Get("author").String())
+	assert.Equal(t, "Hi", previousMessages[0].Get("text").String())
+	assert.Equal(t, "bot", previousMessages[1].Get("author").String())
+	assert.Equal(t, "Chat", previousMessages[1].Get("messageType").String())
+}
@@ -1,25 +1,31 @@
 package sydney
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/url"
 	"regexp"
 	"strings"
-	"sydneyqt/util"
 	"time"
+
+	"github.com/imroc/req/v3"
 )
 
-func (o *Sydney) GenerateImage(generativeImage GenerativeImage) (GenerateImageResult, error) {
+func (o *Sydney) GenerateImage(ctx context.Context, generativeImage GenerativeImage) (GenerateImageResult, error) {
 	start := time.Now()
 	var empty GenerateImageResult
-	_, client, err := util.MakeHTTPClient(o.proxy, 15*time.Second)
+	_, client, err := o.makeHTTPClient(15 * time.Second)
 	if err != nil {
 		return empty, err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1").
-		SetCommonHeader("Cookie", util.FormatCookieString(o.cookies))
-	resp, err := client.R().Get(generativeImage.URL)
+	headers := map[string]string{
+		"Referer": "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1",
+		"Cookie":  o.cookieString(),
+	}
+	resp, err := o.retryRequest(ctx, "create image", func() (*req.Response, error) {
+		return client.R().SetContext(ctx).SetHeaders(headers).Get(generativeImage.URL)
+	})
 	if err != nil {
 		return empty, err
 	}
@@ -29,12 +35,18 @@ func (o *Sydney) GenerateImage(generativeImage GenerativeImage) (GenerateImageRe
 	}
 	resultID := arr[1]
 	re := regexp.MustCompile(`<img class="mimg".*?src="(.*?)"`)
-	u := "https://www.bing.com/images/create/async/results/" + resultID +
+	u := o.endpoints.ImageCreateResults + "/" + resultID +
 		"?q=" + url.QueryEscape(generativeImage.Text) + "&partner=sydney&showselective=1&IID=images.as"
 	slog.Info("Result URL", "v", u)
 	for i := 0; i < 15; i++ {
-		time.Sleep(3 * time.Second)
-		resp, err := client.R().Get(u)
+		select {
+		case <-ctx.Done():
+			return empty, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+		resp, err := o.retryRequest(ctx, "poll created images", func() (*req.Response, error) {
+			return client.R().SetContext(ctx).SetHeaders(headers).Get(u)
+		})
 		if err != nil {
 			return empty, err
 		}
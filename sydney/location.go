@@ -0,0 +1,133 @@
+package sydney
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// Location is where Bing thinks the user is, which biases search results.
+type Location struct {
+	// Preset is the name of one of LocationPresets. The non-zero fields below override it.
+	// If empty, the explicit coordinates are used, or Los Angeles if there is none.
+	Preset    string  `json:"preset"`
+	Name      string  `json:"name"` // city
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Country   string  `json:"country"`
+	Admin1    string  `json:"admin1"` // state or province
+	PostCode  string  `json:"post_code"`
+	UtcOffset int     `json:"utc_offset"`
+	Dma       int     `json:"dma"`
+	// Region is the country code sent as the region, e.g. GB. If empty, it is the one of the
+	// chosen preset, or else derived from the locale, e.g. CN for zh-CN.
+	Region string `json:"region"`
+	// Market is the market of the search results, e.g. en-GB. It is the locale if empty.
+	Market string `json:"market"`
+}
+
+const defaultLocationPreset = "Los Angeles"
+
+var LocationPresets = []Location{
+	{Name: "Los Angeles", Latitude: 33.97570037841797, Longitude: -118.25640106201172, Country: "United States",
+		Admin1: "California", PostCode: "90060", UtcOffset: -8, Dma: 803, Region: "US"},
+	{Name: "New York", Latitude: 40.7128, Longitude: -74.006, Country: "United States",
+		Admin1: "New York", UtcOffset: -5, Region: "US"},
+	{Name: "Toronto", Latitude: 43.6532, Longitude: -79.3832, Country: "Canada",
+		Admin1: "Ontario", UtcOffset: -5, Region: "CA"},
+	{Name: "São Paulo", Latitude: -23.5505, Longitude: -46.6333, Country: "Brazil",
+		Admin1: "São Paulo", UtcOffset: -3, Region: "BR"},
+	{Name: "London", Latitude: 51.5074, Longitude: -0.1278, Country: "United Kingdom",
+		Admin1: "England", UtcOffset: 0, Region: "GB"},
+	{Name: "Paris", Latitude: 48.8566, Longitude: 2.3522, Country: "France",
+		Admin1: "Île-de-France", UtcOffset: 1, Region: "FR"},
+	{Name: "Berlin", Latitude: 52.52, Longitude: 13.405, Country: "Germany",
+		Admin1: "Berlin", UtcOffset: 1, Region: "DE"},
+	{Name: "Amsterdam", Latitude: 52.3676, Longitude: 4.9041, Country: "Netherlands",
+		Admin1: "North Holland", UtcOffset: 1, Region: "NL"},
+	{Name: "Madrid", Latitude: 40.4168, Longitude: -3.7038, Country: "Spain",
+		Admin1: "Madrid", UtcOffset: 1, Region: "ES"},
+	{Name: "Tokyo", Latitude: 35.6762, Longitude: 139.6503, Country: "Japan",
+		Admin1: "Tokyo", UtcOffset: 9, Region: "JP"},
+	{Name: "Seoul", Latitude: 37.5665, Longitude: 126.978, Country: "South Korea",
+		Admin1: "Seoul", UtcOffset: 9, Region: "KR"},
+	{Name: "Singapore", Latitude: 1.3521, Longitude: 103.8198, Country: "Singapore",
+		Admin1: "Singapore", UtcOffset: 8, Region: "SG"},
+	{Name: "Hong Kong", Latitude: 22.3193, Longitude: 114.1694, Country: "Hong Kong SAR",
+		Admin1: "Hong Kong", UtcOffset: 8, Region: "HK"},
+	{Name: "Taipei", Latitude: 25.033, Longitude: 121.5654, Country: "Taiwan",
+		Admin1: "Taipei", UtcOffset: 8, Region: "TW"},
+	{Name: "Sydney", Latitude: -33.8688, Longitude: 151.2093, Country: "Australia",
+		Admin1: "New South Wales", UtcOffset: 10, Region: "AU"},
+}
+
+// resolveLocation returns the location with the preset applied and the region and the market
+// filled in from the locale. The default preset only supplies the coordinates, so without a
+// location chosen the region still follows the locale.
+func resolveLocation(location Location, locale string) Location {
+	var resolved Location
+	presetName := location.Preset
+	if presetName == "" && location.Latitude == 0 && location.Longitude == 0 {
+		presetName = defaultLocationPreset
+	}
+	if presetName != "" {
+		preset, ok := lo.Find(LocationPresets, func(item Location) bool {
+			return strings.EqualFold(item.Name, presetName)
+		})
+		if !ok {
+			slog.Warn("Location preset not found", "param", presetName, "fallback-to", defaultLocationPreset)
+			preset, _ = lo.Find(LocationPresets, func(item Location) bool {
+				return item.Name == defaultLocationPreset
+			})
+		}
+		resolved = preset
+		if !ok || location.Preset == "" {
+			resolved.Region = ""
+		}
+	}
+	resolved.Name = lo.Ternary(location.Name == "", resolved.Name, location.Name)
+	resolved.Latitude = lo.Ternary(location.Latitude == 0, resolved.Latitude, location.Latitude)
+	resolved.Longitude = lo.Ternary(location.Longitude == 0, resolved.Longitude, location.Longitude)
+	resolved.Country = lo.Ternary(location.Country == "", resolved.Country, location.Country)
+	resolved.Admin1 = lo.Ternary(location.Admin1 == "", resolved.Admin1, location.Admin1)
+	resolved.PostCode = lo.Ternary(location.PostCode == "", resolved.PostCode, location.PostCode)
+	resolved.UtcOffset = lo.Ternary(location.UtcOffset == 0, resolved.UtcOffset, location.UtcOffset)
+	resolved.Dma = lo.Ternary(location.Dma == 0, resolved.Dma, location.Dma)
+	resolved.Region = lo.Ternary(location.Region == "", resolved.Region, location.Region)
+	if resolved.Region == "" {
+		_, region, ok := strings.Cut(locale, "-")
+		resolved.Region = lo.Ternary(ok, strings.ToUpper(region), "US")
+	}
+	resolved.Market = lo.Ternary(location.Market == "", locale, location.Market)
+	return resolved
+}
+
+func (o Location) locationHint() LocationHint {
+	return LocationHint{
+		SourceType: 1,
+		RegionType: 2,
+		Center: LatLng{
+			Latitude:  o.Latitude,
+			Longitude: o.Longitude,
+		},
+		Radius:                   24902,
+		Name:                     strings.Join(lo.Compact([]string{o.Name, o.Admin1}), ", "),
+		Accuracy:                 24902,
+		FDConfidence:             0.5,
+		CountryName:              o.Country,
+		CountryConfidence:        8,
+		Admin1Name:               o.Admin1,
+		PopulatedPlaceName:       o.Name,
+		PopulatedPlaceConfidence: 5,
+		PostCodeName:             o.PostCode,
+		UtcOffset:                o.UtcOffset,
+		Dma:                      o.Dma,
+	}
+}
+
+// locationString is the location of the message, e.g. "lat:47.639557;long:-122.128159;re=1000m;".
+func (o Location) locationString() string {
+	return fmt.Sprintf("lat:%.6f;long:%.6f;re=1000m;", o.Latitude, o.Longitude)
+}
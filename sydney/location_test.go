@@ -0,0 +1,49 @@
+package sydney
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveLocation(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		location := resolveLocation(Location{}, "en-US")
+		assert.Equal(t, "Los Angeles, California", location.locationHint().Name)
+		assert.Equal(t, "US", location.Region)
+		assert.Equal(t, "en-US", location.Market)
+		location = resolveLocation(Location{}, "zh-CN")
+		assert.Equal(t, "Los Angeles", location.Name)
+		assert.Equal(t, "CN", location.Region)
+		assert.Equal(t, "zh-CN", location.Market)
+		location = resolveLocation(Location{}, "ja-JP")
+		assert.Equal(t, "JP", location.Region)
+		location = resolveLocation(Location{Region: "GB"}, "ja-JP")
+		assert.Equal(t, "GB", location.Region)
+	})
+	t.Run("preset", func(t *testing.T) {
+		location := resolveLocation(Location{Preset: "tokyo"}, "zh-CN")
+		assert.Equal(t, 35.6762, location.Latitude)
+		assert.Equal(t, "Japan", location.Country)
+		assert.Equal(t, "JP", location.Region)
+		assert.Equal(t, "zh-CN", location.Market)
+		location = resolveLocation(Location{Preset: "London", Region: "GB", Market: "en-GB"}, "en-US")
+		assert.Equal(t, "GB", location.Region)
+		assert.Equal(t, "en-GB", location.Market)
+	})
+	t.Run("explicit", func(t *testing.T) {
+		location := resolveLocation(Location{Latitude: 48.1351, Longitude: 11.582, Country: "Germany"}, "de")
+		assert.Equal(t, LatLng{Latitude: 48.1351, Longitude: 11.582}, location.locationHint().Center)
+		assert.Equal(t, "Germany", location.Country)
+		assert.Empty(t, location.Name)
+		assert.Equal(t, "US", location.Region)
+		assert.Equal(t, "lat:48.135100;long:11.582000;re=1000m;", location.locationString())
+		location = resolveLocation(Location{Latitude: 48.1351, Longitude: 11.582}, "de-DE")
+		assert.Equal(t, "DE", location.Region)
+	})
+	t.Run("unknown preset", func(t *testing.T) {
+		location := resolveLocation(Location{Preset: "Atlantis"}, "fr-FR")
+		assert.Equal(t, "Los Angeles", location.Name)
+		assert.Equal(t, "FR", location.Region)
+	})
+}
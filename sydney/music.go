@@ -1,14 +1,16 @@
 package sydney
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"sydneyqt/sydney/internal/hex"
-	"sydneyqt/util"
 	"time"
+
+	"github.com/imroc/req/v3"
 )
 
 type GenerateMusicRawResponse struct {
@@ -29,18 +31,22 @@ type GenerateMusicRealResponse struct {
 	BingShareHash    string  `json:"bingShareHash"`
 }
 
-func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicResult, error) {
+func (o *Sydney) GenerateMusic(ctx context.Context, generativeMusic GenerativeMusic) (GenerateMusicResult, error) {
 	start := time.Now()
 	var empty GenerateMusicResult
-	_, client, err := util.MakeHTTPClient(o.proxy, 15*time.Second)
+	_, client, err := o.makeHTTPClient(15 * time.Second)
 	if err != nil {
 		return empty, err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1").
-		SetCommonHeader("Cookie", util.FormatCookieString(o.cookies))
-	u0 := "https://www.bing.com/videos/music?vdpp=suno&kseed=8000&SFX=3&q=&" +
+	headers := map[string]string{
+		"Referer": "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1",
+		"Cookie":  o.cookieString(),
+	}
+	u0 := o.endpoints.MusicPage + "?vdpp=suno&kseed=8000&SFX=3&q=&" +
 		"iframeid=" + generativeMusic.IFrameID + "&requestid=" + generativeMusic.RequestID
-	resp, err := client.R().Get(u0)
+	resp, err := o.retryRequest(ctx, "create music", func() (*req.Response, error) {
+		return client.R().SetContext(ctx).SetHeaders(headers).Get(u0)
+	})
 	if err != nil {
 		return empty, err
 	}
@@ -51,14 +57,20 @@ func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicRe
 	if len(arr) < 2 {
 		return empty, errors.New("cannot find music creation skey")
 	}
-	u1 := "https://www.bing.com/videos/api/custom/music?skey=" + arr[1] +
+	u1 := o.endpoints.MusicAPI + "?skey=" + arr[1] +
 		"&safesearch=Moderate&vdpp=suno&" +
 		"requestid=" + generativeMusic.RequestID + "&" +
 		"ig=" + hex.NewUpperHex(32) + "&iid=vsn&sfx=1"
 	slog.Info("Result URL", "v", u1)
 	for i := 0; i < 15; i++ {
-		time.Sleep(3 * time.Second)
-		resp, err = client.R().SetHeader("Referer", u0).Get(u1)
+		select {
+		case <-ctx.Done():
+			return empty, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+		resp, err = o.retryRequest(ctx, "poll created music", func() (*req.Response, error) {
+			return client.R().SetContext(ctx).SetHeaders(headers).SetHeader("Referer", u0).Get(u1)
+		})
 		if err != nil {
 			return empty, err
 		}
@@ -82,9 +94,9 @@ func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicRe
 		}
 		return GenerateMusicResult{
 			GenerativeMusic: generativeMusic,
-			CoverImgURL:     "https://th.bing.com/th?&id=" + realResp.ImageKey,
-			AudioURL:        "https://th.bing.com/th?&id=" + realResp.AudioKey,
-			VideoURL:        "https://th.bing.com/th?&id=" + realResp.VideoKey,
+			CoverImgURL:     o.endpoints.Thumbnail + "?&id=" + realResp.ImageKey,
+			AudioURL:        o.endpoints.Thumbnail + "?&id=" + realResp.AudioKey,
+			VideoURL:        o.endpoints.Thumbnail + "?&id=" + realResp.VideoKey,
 			MusicDuration:   time.Duration(realResp.Duration * float64(time.Second)),
 			MusicalStyle:    realResp.MusicalStyle,
 			Title:           realResp.GptPrompt,
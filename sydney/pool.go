@@ -0,0 +1,257 @@
+package sydney
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ConversationPoolOptions configures ConversationPool.
+type ConversationPoolOptions struct {
+	Size int // conversations kept ready per account
+	// MaxAge is how long a conversation is kept before it is discarded and replaced with a
+	// new one. 5 minutes if zero.
+	MaxAge time.Duration
+	// Connect also connects to ChatHub for the conversations, which saves the dial and the
+	// handshake as well. The connections are kept alive with pings.
+	Connect bool
+	// IdleTimeout is how long the conversations of an account are kept after it was last
+	// used, e.g. while it is cooling down or after its cookies have changed. 30 minutes if zero.
+	IdleTimeout time.Duration
+}
+
+// ConversationPool keeps conversations created in advance for each account, so that an answer
+// doesn't have to wait for the conversation to be created. Conversations taken from the pool
+// are replaced in the background. The pool is shared by all Sydney instances created with it
+// in Options.ConversationPool, and the conversations are kept by the _U cookie.
+type ConversationPool struct {
+	options ConversationPoolOptions
+
+	mu       sync.Mutex
+	entries  map[string][]pooledConversation // by account key, the oldest first
+	filling  map[string]int                  // conversations being created by account key
+	accounts map[string]*pooledAccount       // by account key
+	closed   bool
+	stop     chan struct{}
+}
+
+type pooledConversation struct {
+	conversation CreateConversationResponse
+	conn         *Conn // nil unless ConversationPoolOptions.Connect
+	created      time.Time
+}
+
+type pooledAccount struct {
+	sydney   *Sydney   // the latest Sydney of the account, for refilling
+	lastUsed time.Time // of Warm or a take
+	// failed is set if creating a conversation has failed, so that the account is not
+	// refilled until it is used again.
+	failed bool
+}
+
+// NewConversationPool returns a pool which keeps ConversationPoolOptions.Size conversations
+// ready for each account it has been used with. Close it to release the connections.
+func NewConversationPool(options ConversationPoolOptions) *ConversationPool {
+	if options.MaxAge <= 0 {
+		options.MaxAge = 5 * time.Minute
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = 30 * time.Minute
+	}
+	o := &ConversationPool{
+		options:  options,
+		entries:  map[string][]pooledConversation{},
+		filling:  map[string]int{},
+		accounts: map[string]*pooledAccount{},
+		stop:     make(chan struct{}),
+	}
+	go o.maintain()
+	return o
+}
+
+// Options returns the options of the pool.
+func (o *ConversationPool) Options() ConversationPoolOptions {
+	return o.options
+}
+
+// Warm fills the pool for the account of the Sydney in the background, so that even its
+// first answer finds a conversation ready.
+func (o *ConversationPool) Warm(sydney *Sydney) {
+	o.fill(sydney)
+}
+
+// Ready returns the number of conversations ready for the account of the Sydney.
+func (o *ConversationPool) Ready(sydney *Sydney) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries[sydney.accountKey()])
+}
+
+// Close discards all conversations and closes their connections. Sydney instances using the
+// pool create conversations themselves afterward.
+func (o *ConversationPool) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	close(o.stop)
+	for _, entries := range o.entries {
+		for _, entry := range entries {
+			entry.close()
+		}
+	}
+	o.entries = map[string][]pooledConversation{}
+}
+
+// takePooledConversation takes a conversation from the pool, if any.
+func (o *Sydney) takePooledConversation() (pooledConversation, bool) {
+	if o.pool == nil {
+		return pooledConversation{}, false
+	}
+	return o.pool.take(o)
+}
+
+// take returns the newest conversation ready for the account of the Sydney, if any, and
+// starts replacing it.
+func (o *ConversationPool) take(sydney *Sydney) (pooledConversation, bool) {
+	key := sydney.accountKey()
+	o.mu.Lock()
+	o.discardExpired(key)
+	entries := o.entries[key]
+	var entry pooledConversation
+	ok := len(entries) != 0
+	if ok {
+		entry = entries[len(entries)-1]
+		o.entries[key] = entries[:len(entries)-1]
+	}
+	o.mu.Unlock()
+	o.fill(sydney)
+	return entry, ok
+}
+
+// fill marks the account of the Sydney as used and refills it.
+func (o *ConversationPool) fill(sydney *Sydney) {
+	o.mu.Lock()
+	if !o.closed {
+		o.accounts[sydney.accountKey()] = &pooledAccount{sydney: sydney, lastUsed: time.Now()}
+	}
+	o.mu.Unlock()
+	o.refill(sydney)
+}
+
+// refill creates conversations in the background until the account has Size of them.
+func (o *ConversationPool) refill(sydney *Sydney) {
+	key := sydney.accountKey()
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	missing := o.options.Size - len(o.entries[key]) - o.filling[key]
+	if missing > 0 {
+		o.filling[key] += missing
+	}
+	o.mu.Unlock()
+	for i := 0; i < missing; i++ {
+		go o.create(key, sydney)
+	}
+}
+
+func (o *ConversationPool) create(key string, sydney *Sydney) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	entry := pooledConversation{}
+	var err error
+	entry.conversation, err = sydney.createConversation(ctx)
+	if err == nil && o.options.Connect {
+		entry.conn, err = sydney.connectChatHub(ctx, entry.conversation)
+		if err != nil {
+			slog.Warn("Cannot connect to ChatHub for the conversation pool", "err", err)
+			err = nil // the conversation is still of use
+		}
+	}
+	entry.created = time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.filling[key]--
+	account, ok := o.accounts[key]
+	if err != nil {
+		slog.Warn("Cannot create a conversation for the pool", "err", err)
+		if ok {
+			account.failed = true
+		}
+		return
+	}
+	if o.closed || !ok { // evicted meanwhile
+		entry.close()
+		return
+	}
+	o.entries[key] = append(o.entries[key], entry)
+}
+
+// discardExpired discards the expired conversations of the account. The caller must hold mu.
+func (o *ConversationPool) discardExpired(key string) {
+	entries := o.entries[key]
+	i := 0
+	for i < len(entries) && time.Since(entries[i].created) >= o.options.MaxAge {
+		entries[i].close()
+		i++
+	}
+	o.entries[key] = entries[i:]
+}
+
+// evict discards the conversations of the account and stops refilling it. The caller must hold
+// mu.
+func (o *ConversationPool) evict(key string) {
+	for _, entry := range o.entries[key] {
+		entry.close()
+	}
+	delete(o.entries, key)
+	delete(o.accounts, key)
+}
+
+// maintain replaces the expired conversations of the accounts in use and evicts the idle ones
+// until the pool is closed.
+func (o *ConversationPool) maintain() {
+	ticker := time.NewTicker(max(o.options.MaxAge/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+		}
+		o.mu.Lock()
+		var sydneys []*Sydney
+		for key, account := range o.accounts {
+			if time.Since(account.lastUsed) >= o.options.IdleTimeout {
+				o.evict(key)
+				continue
+			}
+			o.discardExpired(key)
+			if !account.failed {
+				sydneys = append(sydneys, account.sydney)
+			}
+		}
+		o.mu.Unlock()
+		for _, sydney := range sydneys {
+			o.refill(sydney)
+		}
+	}
+}
+
+func (o pooledConversation) close() {
+	if o.conn != nil {
+		o.conn.CloseNow()
+	}
+}
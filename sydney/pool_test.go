@@ -0,0 +1,125 @@
+package sydney
+
+import (
+	"context"
+	"strconv"
+	"sydneyqt/sydney/sydneytest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestConversationPool(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	newPooledSydney := func(pool *ConversationPool) *Sydney {
+		return NewSydney(Options{
+			Cookies:          map[string]string{"_U": "fake"},
+			Endpoints:        LocalEndpoints(server.URL),
+			ConversationPool: pool,
+		})
+	}
+	ask := func(syd *Sydney) string {
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, "Hello, this is Bing.", messageText(collect(ch)))
+		requests := server.ChatRequests()
+		return gjson.Parse(requests[len(requests)-1]).Get("arguments.0.conversationId").String()
+	}
+	t.Run("conversations", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 2})
+		defer pool.Close()
+		syd := newPooledSydney(pool)
+		created := server.Conversations()
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool {
+			return pool.Ready(syd) == 2
+		}, 2*time.Second, 10*time.Millisecond)
+		conversationID := ask(newPooledSydney(pool)) // a new instance of the same account
+		assert.Contains(t, []string{"fake-conversation-" + strconv.Itoa(created+1), "fake-conversation-" + strconv.Itoa(created+2)},
+			conversationID)
+		assert.Eventually(t, func() bool { // replaced in the background
+			return pool.Ready(syd) == 2 && server.Conversations() == created+3
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+	t.Run("connections", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 1, Connect: true})
+		defer pool.Close()
+		syd := newPooledSydney(pool)
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool {
+			return pool.Ready(syd) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		connections := server.Connections()
+		ask(syd)
+		assert.Eventually(t, func() bool {
+			return pool.Ready(syd) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, connections+1, server.Connections()) // only the replacement connected
+	})
+	t.Run("expiry", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 1, MaxAge: 100 * time.Millisecond})
+		defer pool.Close()
+		syd := newPooledSydney(pool)
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool {
+			return pool.Ready(syd) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		created := server.Conversations()
+		assert.Eventually(t, func() bool { // replaced after expiring
+			return server.Conversations() > created
+		}, 2*time.Second, 10*time.Millisecond)
+		entry, ok := pool.take(syd)
+		assert.True(t, !ok || time.Since(entry.created) < 100*time.Millisecond)
+	})
+	t.Run("idle", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 1, MaxAge: 40 * time.Millisecond,
+			IdleTimeout: 100 * time.Millisecond})
+		defer pool.Close()
+		syd := newPooledSydney(pool)
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool { // evicted once the account is no longer used
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return len(pool.accounts) == 0
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, 0, pool.Ready(syd))
+		created := server.Conversations()
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, created, server.Conversations())
+	})
+	t.Run("failed", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 1, MaxAge: 40 * time.Millisecond})
+		defer pool.Close()
+		syd := NewSydney(Options{
+			Cookies:          map[string]string{"_U": "fake"},
+			Endpoints:        LocalEndpoints(server.URL),
+			ConversationPool: pool,
+			RetryPolicy:      RetryPolicy{MaxAttempts: 1},
+		})
+		server.SetCreateConversationStatus(403)
+		defer server.SetCreateConversationStatus(0)
+		calls := server.CreateConversationCalls()
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool {
+			return server.CreateConversationCalls() == calls+1
+		}, 2*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond) // not refilled until used again
+		assert.Equal(t, calls+1, server.CreateConversationCalls())
+	})
+	t.Run("closed", func(t *testing.T) {
+		pool := NewConversationPool(ConversationPoolOptions{Size: 1, Connect: true})
+		syd := newPooledSydney(pool)
+		pool.Warm(syd)
+		assert.Eventually(t, func() bool {
+			return pool.Ready(syd) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		pool.Close()
+		assert.Equal(t, 0, pool.Ready(syd))
+		ask(syd)
+	})
+}
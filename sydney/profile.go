@@ -0,0 +1,173 @@
+package sydney
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/samber/lo"
+)
+
+const (
+	ClientProfileEdgeDesktop = "edge_desktop"
+	ClientProfileEdgeMobile  = "edge_mobile"
+	ClientProfileBingApp     = "bing_app"
+)
+
+// ClientProfile is the fingerprint of the client Sydney pretends to be.
+type ClientProfile struct {
+	Name string `json:"name"`
+	// Headers are the headers of the ChatHub handshake, e.g. user-agent and sec-ch-ua. The
+	// user-agent and sec-ch-ua headers are also sent with other requests to Bing.
+	Headers  map[string]string `json:"headers"`
+	Source   string            `json:"source"`   // source of the chat request, e.g. cib-ccp
+	Scenario string            `json:"scenario"` // scenario of the chat request, e.g. SERP
+	// ImpersonateChrome makes the HTTP client use the TLS fingerprint of Chrome, which only
+	// fits Chromium-based clients.
+	ImpersonateChrome bool `json:"impersonate_chrome"`
+	// ForwardedIPPrefix is the prefix of the random x-forwarded-for header, e.g. "1.0.0.".
+	// The header is not sent if empty.
+	ForwardedIPPrefix string `json:"forwarded_ip_prefix"`
+}
+
+var ClientProfiles = []ClientProfile{
+	{
+		Name: ClientProfileEdgeDesktop,
+		Headers: map[string]string{
+			"accept":                      "application/json",
+			"accept-language":             "en-US,en;q=0.9",
+			"content-type":                "application/json",
+			"sec-ch-ua":                   `"Microsoft Edge";v="113", "Chromium";v="113", "Not-A.Brand";v="24"`,
+			"sec-ch-ua-arch":              `"x86"`,
+			"sec-ch-ua-bitness":           `"64"`,
+			"sec-ch-ua-full-version":      `"113.0.1774.50"`,
+			"sec-ch-ua-full-version-list": `"Microsoft Edge";v="113.0.1774.50", "Chromium";v="113.0.5672.127", "Not-A.Brand";v="24.0.0.0"`,
+			"sec-ch-ua-mobile":            "?0",
+			"sec-ch-ua-model":             `""`,
+			"sec-ch-ua-platform":          `"Windows"`,
+			"sec-ch-ua-platform-version":  `"15.0.0"`,
+			"sec-fetch-dest":              "empty",
+			"sec-fetch-mode":              "cors",
+			"sec-fetch-site":              "same-origin",
+			"sec-ms-gec-version":          "1-115.0.1866.1",
+			"x-ms-useragent":              "azsdk-js-api-client-factory/1.0.0-beta.1 core-rest-pipeline/1.10.0 OS/Win32",
+			"user-agent":                  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.0.0 Safari/537.36 Edg/113.0.1774.50",
+			"Referer":                     "https://www.bing.com/search?q=Bing+AI&showconv=1",
+			"Referrer-Policy":             "origin-when-cross-origin",
+		},
+		Source:            "cib-ccp",
+		Scenario:          "SERP",
+		ImpersonateChrome: true,
+		ForwardedIPPrefix: "1.0.0.",
+	},
+	{
+		Name: ClientProfileEdgeMobile,
+		Headers: map[string]string{
+			"accept":                      "application/json",
+			"accept-language":             "en-US,en;q=0.9",
+			"content-type":                "application/json",
+			"sec-ch-ua":                   `"Microsoft Edge";v="121", "Chromium";v="121", "Not A(Brand";v="99"`,
+			"sec-ch-ua-arch":              `""`,
+			"sec-ch-ua-bitness":           `""`,
+			"sec-ch-ua-full-version":      `"121.0.2277.138"`,
+			"sec-ch-ua-full-version-list": `"Microsoft Edge";v="121.0.2277.138", "Chromium";v="121.0.6167.178", "Not A(Brand";v="99.0.0.0"`,
+			"sec-ch-ua-mobile":            "?1",
+			"sec-ch-ua-model":             `"Pixel 7"`,
+			"sec-ch-ua-platform":          `"Android"`,
+			"sec-ch-ua-platform-version":  `"13.0.0"`,
+			"sec-fetch-dest":              "empty",
+			"sec-fetch-mode":              "cors",
+			"sec-fetch-site":              "same-origin",
+			"sec-ms-gec-version":          "1-121.0.2277.138",
+			"x-ms-useragent":              "azsdk-js-api-client-factory/1.0.0-beta.1 core-rest-pipeline/1.10.0 OS/Android",
+			"user-agent":                  "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Mobile Safari/537.36 EdgA/121.0.2277.138",
+			"Referer":                     "https://www.bing.com/search?q=Bing+AI&showconv=1",
+			"Referrer-Policy":             "origin-when-cross-origin",
+		},
+		Source:            "cib-ccp",
+		Scenario:          "SERP",
+		ImpersonateChrome: true,
+		ForwardedIPPrefix: "1.0.0.",
+	},
+	{
+		Name: ClientProfileBingApp,
+		Headers: map[string]string{
+			"accept":                     "application/json",
+			"accept-language":            "en-US,en;q=0.9",
+			"content-type":               "application/json",
+			"sec-ch-ua":                  `"Android WebView";v="121", "Chromium";v="121", "Not A(Brand";v="99"`,
+			"sec-ch-ua-mobile":           "?1",
+			"sec-ch-ua-platform":         `"Android"`,
+			"sec-ch-ua-platform-version": `"13.0.0"`,
+			"sec-fetch-dest":             "empty",
+			"sec-fetch-mode":             "cors",
+			"sec-fetch-site":             "same-origin",
+			"x-ms-useragent":             "azsdk-js-api-client-factory/1.0.0-beta.1 core-rest-pipeline/1.10.0 OS/Android",
+			"user-agent":                 "Mozilla/5.0 (Linux; Android 13; Pixel 7 Build/TQ3A.230805.001; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/121.0.6167.178 Mobile Safari/537.36 BingSapphire/28.1.430214303",
+			"x-requested-with":           "com.microsoft.bing",
+			"Referer":                    "https://www.bing.com/chat?showconv=1",
+			"Referrer-Policy":            "origin-when-cross-origin",
+		},
+		Source:            "bingapp",
+		Scenario:          "SERP",
+		ImpersonateChrome: true,
+	},
+}
+
+// ReadClientProfiles reads custom client profiles from a JSON file holding an array of them.
+func ReadClientProfiles(file string) ([]ClientProfile, error) {
+	v, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []ClientProfile
+	if err := json.Unmarshal(v, &profiles); err != nil {
+		return nil, errors.New("cannot parse client profiles in " + file + ": " + err.Error())
+	}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, errors.New("client profile without a name in " + file)
+		}
+	}
+	return profiles, nil
+}
+
+// findClientProfile returns the profile of the name from the custom profiles, then from the
+// built-in ones.
+func findClientProfile(name string, custom []ClientProfile) (ClientProfile, bool) {
+	return lo.Find(append(append([]ClientProfile{}, custom...), ClientProfiles...), func(item ClientProfile) bool {
+		return item.Name == name
+	})
+}
+
+// fingerprintHeaders are the headers identifying the client, sent with every request.
+func (o ClientProfile) fingerprintHeaders() map[string]string {
+	return lo.PickBy(o.Headers, func(key string, value string) bool {
+		key = strings.ToLower(key)
+		return key == "user-agent" || strings.HasPrefix(key, "sec-ch-ua")
+	})
+}
+
+func (o ClientProfile) forwardedIP() string {
+	if o.ForwardedIPPrefix == "" {
+		return ""
+	}
+	return o.ForwardedIPPrefix + strconv.Itoa(util.RandIntInclusive(1, 255))
+}
+
+// makeHTTPClient returns the HTTP clients to talk to Bing as the client profile. They are
+// shared, so set headers on the requests.
+func (o *Sydney) makeHTTPClient(timeout time.Duration) (*http.Client, *req.Client, error) {
+	return util.DefaultHTTPClientFactory.Clients(util.HTTPClientOptions{
+		Proxy:             o.proxy,
+		Timeout:           timeout,
+		ImpersonateChrome: o.profile.ImpersonateChrome,
+		Headers:           o.profile.fingerprintHeaders(),
+	})
+}
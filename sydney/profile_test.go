@@ -0,0 +1,58 @@
+package sydney
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sydneyqt/sydney/sydneytest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestClientProfile(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		syd := NewSydney(Options{})
+		assert.Equal(t, ClientProfileEdgeDesktop, syd.profile.Name)
+		assert.Contains(t, syd.headers()["user-agent"], "Edg/")
+		assert.NotEmpty(t, syd.headers()["x-forwarded-for"])
+		syd = NewSydney(Options{ClientProfile: "unknown"})
+		assert.Equal(t, ClientProfileEdgeDesktop, syd.profile.Name)
+	})
+	t.Run("custom", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "profiles.json")
+		assert.Nil(t, os.WriteFile(file, []byte(`[{"name": "firefox", "source": "cib", "scenario": "Test",
+			"headers": {"user-agent": "Firefox/125.0", "accept": "application/json"}}]`), 0644))
+		profiles, err := ReadClientProfiles(file)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		server := sydneytest.NewServer()
+		defer server.Close()
+		syd := NewSydney(Options{
+			Cookies:              map[string]string{"_U": "fake"},
+			Endpoints:            LocalEndpoints(server.URL),
+			ClientProfile:        "firefox",
+			CustomClientProfiles: profiles,
+		})
+		headers := syd.headers()
+		assert.Equal(t, "Firefox/125.0", headers["user-agent"])
+		assert.NotContains(t, headers, "x-forwarded-for")
+		assert.NotContains(t, headers, "sec-ch-ua")
+		server.Enqueue(sydneytest.TextScenario("Hello"))
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello", messageText(collect(ch)))
+		requests := server.ChatRequests()
+		request := gjson.Parse(requests[len(requests)-1])
+		assert.Equal(t, "cib", request.Get("arguments.0.source").String())
+		assert.Equal(t, "Test", request.Get("arguments.0.scenario").String())
+	})
+	t.Run("invalid file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "profiles.json")
+		assert.Nil(t, os.WriteFile(file, []byte(`[{"source": "cib"}]`), 0644))
+		_, err := ReadClientProfiles(file)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,125 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// RetryPolicy is how the requests made before the answer starts streaming are retried on
+// transient failures: creating the conversation, connecting to ChatHub, uploading images and
+// files, and polling for generated images and music. Nothing is retried once text has been
+// streamed; resuming the answer is up to Options.ReconnectAttempts instead.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one. Zero means the default,
+	// and 1 or a negative value disables retrying.
+	MaxAttempts int
+	// InitialBackoff is the wait before the second attempt, which doubles for each further
+	// attempt up to MaxBackoff. A random jitter of up to half of the wait is subtracted.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable reports whether the error is transient. IsRetryable if nil.
+	Retryable func(err error) bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Retryable:      IsRetryable,
+}
+
+// withDefaults replaces the zero fields with the default ones.
+func (o RetryPolicy) withDefaults() RetryPolicy {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	o.MaxAttempts = max(o.MaxAttempts, 1)
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = max(DefaultRetryPolicy.MaxBackoff, o.InitialBackoff)
+	}
+	if o.Retryable == nil {
+		o.Retryable = DefaultRetryPolicy.Retryable
+	}
+	return o
+}
+
+// backoff returns the wait after the failed attempt, counted from 1.
+func (o RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := o.MaxBackoff
+	if attempt-1 < 32 {
+		backoff = min(o.InitialBackoff<<(attempt-1), o.MaxBackoff)
+	}
+	if backoff <= 0 { // overflowed
+		backoff = o.MaxBackoff
+	}
+	return backoff - time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// IsRetryable reports whether the error is transient: a 5xx status code, a network error
+// such as a reset connection or an interrupted TLS handshake, or a dial or write timeout.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var bingErr *BingError
+	if errors.As(err, &bingErr) {
+		return bingErr.StatusCode >= 500
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Op == TimeoutDial || timeoutErr.Op == TimeoutWrite
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryRequest sends the request with retries. A 5xx status code fails the attempt.
+func (o *Sydney) retryRequest(ctx context.Context, operation string, send func() (*req.Response, error)) (*req.Response, error) {
+	return retry(ctx, o.retryPolicy, operation, func() (*req.Response, error) {
+		resp, err := send()
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetStatusCode() >= 500 {
+			return nil, newStatusError(operation, resp.GetStatusCode())
+		}
+		return resp, nil
+	})
+}
+
+// retry calls fn until it succeeds, fails with an error which is not retryable, or runs out of
+// attempts. It gives up waiting for the next attempt once ctx is done.
+func retry[T any](ctx context.Context, policy RetryPolicy, operation string, fn func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.Retryable(err) {
+			return result, err
+		}
+		backoff := policy.backoff(attempt)
+		slog.Warn("Retrying after a transient failure", "operation", operation, "attempt", attempt,
+			"max", policy.MaxAttempts, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(backoff):
+		}
+	}
+}
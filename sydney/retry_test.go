@@ -0,0 +1,90 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sydneyqt/sydney/sydneytest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(newStatusError("create conversation", 503)))
+	assert.True(t, IsRetryable(newConversationCreateError(502, "", "")))
+	assert.False(t, IsRetryable(newConversationCreateError(403, "", "")))
+	assert.False(t, IsRetryable(newResultError("Throttled", "")))
+	assert.True(t, IsRetryable(&TimeoutError{Op: TimeoutDial}))
+	assert.False(t, IsRetryable(&TimeoutError{Op: TimeoutTotal}))
+	assert.True(t, IsRetryable(&net.OpError{Op: "read", Err: syscall.ECONNRESET}))
+	assert.True(t, IsRetryable(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.False(t, IsRetryable(&net.OpError{Op: "read", Err: syscall.EINVAL}))
+	assert.True(t, IsRetryable(io.ErrUnexpectedEOF))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(errors.New("blobId is empty")))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	newSydney := func(policy RetryPolicy) *Sydney {
+		return NewSydney(Options{
+			Cookies:     map[string]string{"_U": "fake"},
+			Endpoints:   LocalEndpoints(server.URL),
+			RetryPolicy: policy,
+		})
+	}
+	t.Run("backoff", func(t *testing.T) {
+		policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+		for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond,
+			400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+			backoff := policy.backoff(attempt + 1)
+			assert.LessOrEqual(t, backoff, want)
+			assert.GreaterOrEqual(t, backoff, want/2)
+		}
+		assert.GreaterOrEqual(t, policy.backoff(100), time.Second/2)
+	})
+	t.Run("transient failures", func(t *testing.T) {
+		calls := server.CreateConversationCalls()
+		server.FailCreateConversation(503, 2)
+		syd := newSydney(RetryPolicy{InitialBackoff: time.Millisecond})
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, "Hello, this is Bing.", messageText(collect(ch)))
+		assert.Equal(t, 3, server.CreateConversationCalls()-calls)
+	})
+	t.Run("out of attempts", func(t *testing.T) {
+		calls := server.CreateConversationCalls()
+		server.FailCreateConversation(503, 3)
+		defer server.SetCreateConversationStatus(0)
+		syd := newSydney(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+		_, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.ErrorIs(t, err, ErrConversationCreate)
+		assert.Equal(t, 2, server.CreateConversationCalls()-calls)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		calls := server.CreateConversationCalls()
+		server.SetCreateConversationStatus(403)
+		defer server.SetCreateConversationStatus(0)
+		_, err := newSydney(RetryPolicy{}).AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		assert.Equal(t, 1, server.CreateConversationCalls()-calls)
+	})
+	t.Run("stopped while waiting", func(t *testing.T) {
+		server.SetCreateConversationStatus(503)
+		defer server.SetCreateConversationStatus(0)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		syd := newSydney(RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Minute})
+		_, err := syd.AskStream(AskStreamOptions{StopCtx: ctx, Prompt: "hi"})
+		assert.NotNil(t, err)
+		assert.Less(t, time.Since(start), 10*time.Second)
+	})
+}
@@ -0,0 +1,147 @@
+package sydney
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Session is a multi-turn conversation with Bing. The conversation is created on the first
+// turn and reused afterward, so follow-up questions don't need to re-send the whole history.
+type Session struct {
+	sydney       *Sydney
+	mu           sync.Mutex
+	conversation CreateConversationResponse
+	invocationID int
+	throttling   *Throttling
+	busy         bool // a turn is being answered
+}
+
+// sessionTurn is a turn of a session reserved by Session.Ask. It is kept by the options of the
+// answer, so that the question asked again after a CAPTCHA is still the same turn.
+type sessionTurn struct {
+	session      *Session
+	conversation CreateConversationResponse
+	index        int
+}
+
+func (o *Sydney) NewSession() *Session {
+	return &Session{sydney: o}
+}
+
+// Ask sends a new turn of the session. WebpageContext or History is only needed in the first
+// turn, and is still sent in later turns if provided. It fails with ErrTurnLimitReached if Bing has
+// reported that the conversation accepts no more user messages, and with ErrSessionBusy if the
+// answer of the previous turn is not over yet, i.e. its channel is not closed.
+func (o *Session) Ask(options AskStreamOptions) (<-chan Message, error) {
+	if o.LimitReached() {
+		return nil, ErrTurnLimitReached
+	}
+	if !o.acquire() {
+		return nil, ErrSessionBusy
+	}
+	turn, err := o.nextTurn(options.StopCtx)
+	if err != nil {
+		o.release()
+		return nil, err
+	}
+	options.turn = &turn
+	ch, err := o.sydney.AskStream(options)
+	if err != nil {
+		o.release()
+		return nil, err
+	}
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		defer o.release() // before closing the channel, so that the next turn can follow at once
+		for msg := range ch {
+			if !send(options.StopCtx, out, msg) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Conversation returns the conversation of the session, which is empty before the first turn.
+func (o *Session) Conversation() CreateConversationResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conversation
+}
+
+// Turns returns the number of turns that have been sent.
+func (o *Session) Turns() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.invocationID
+}
+
+// Throttling returns the usage of the conversation reported in the last turn, if any.
+func (o *Session) Throttling() (Throttling, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.throttling == nil {
+		return Throttling{}, false
+	}
+	return *o.throttling, true
+}
+
+// LimitReached reports whether the conversation accepts no more user messages.
+func (o *Session) LimitReached() bool {
+	throttling, ok := o.Throttling()
+	return ok && throttling.RemainingUserMessages() == 0
+}
+
+func (o *Session) setThrottling(throttling Throttling) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.throttling = &throttling
+}
+
+func (o *Session) acquire() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.busy {
+		return false
+	}
+	o.busy = true
+	return true
+}
+
+func (o *Session) release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.busy = false
+}
+
+// nextTurn creates the conversation if needed and returns the upcoming turn. The caller must
+// have acquired the session. The turn is only counted by turnSent, so that a turn failing before
+// it is sent can be retried as is.
+func (o *Session) nextTurn(ctx context.Context) (sessionTurn, error) {
+	o.mu.Lock()
+	turn := sessionTurn{session: o, conversation: o.conversation, index: o.invocationID}
+	o.mu.Unlock()
+	if turn.conversation.ConversationId == "" {
+		conversation, err := o.sydney.createConversation(ctx)
+		if err != nil {
+			return sessionTurn{}, err
+		}
+		o.mu.Lock()
+		o.conversation = conversation
+		o.mu.Unlock()
+		turn.conversation = conversation
+		slog.Info("Session started", "conversation-id", conversation.ConversationId)
+	}
+	return turn, nil
+}
+
+// turnSent counts the turn once its chat message has been written to ChatHub.
+func (o *Session) turnSent(turn int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.invocationID == turn {
+		o.invocationID++
+	}
+}
@@ -8,11 +8,8 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
-	"strings"
 	"sydneyqt/util"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/samber/lo"
@@ -20,332 +17,165 @@ import (
 	"nhooyr.io/websocket"
 )
 
+// send sends the value to the channel unless the context is done first, and reports
+// whether it has been sent.
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 	out := make(chan Message)
 	options.messageID = uuid.New().String()
-	conversation, ch, err := o.AskStreamRaw(options)
+	// the raw stream is stopped as soon as the answer is over, e.g. revoked
+	rawCtx, stopRaw := context.WithCancel(options.StopCtx)
+	rawOptions := options
+	rawOptions.StopCtx = rawCtx
+	conversation, ch, err := o.AskStreamRaw(rawOptions)
 	if err != nil {
+		stopRaw()
 		return nil, err
 	}
 	go func(out chan Message, ch <-chan RawMessage) {
 		defer func() {
 			slog.Info("AskStream is closing out message channel")
+			stopRaw()
 			close(out)
 		}()
-		wrote := 0
-		sendSuggestedResponses := func(message gjson.Result) {
-			if message.Get("suggestedResponses").Exists() {
-				arr := util.Map(message.Get("suggestedResponses").Array(), func(v gjson.Result) string {
-					return v.Get("text").String()
-				})
-				v, _ := json.Marshal(arr)
-				out <- Message{
-					Type: MessageTypeSuggestedResponses,
-					Text: string(v),
-				}
-			}
+		emit := func(msg Message) bool {
+			return send(options.StopCtx, out, msg)
 		}
-		var sourceAttributes []SourceAttribute
-		tmpLastDocLoadingMessage := "" // for removing duplicate doc loading messages
+		decoder := NewStreamDecoder(options.Prompt)
+		decoder.EmitRaw = options.EmitRaw
+		decoder.CitationStyle = options.CitationStyle
+		decoder.endpoints = o.endpoints
 		for msg := range ch {
 			if msg.Error != nil {
 				slog.Error("Ask stream message", "error", msg.Error)
-				if strings.Contains(msg.Error.Error(), "CAPTCHA") {
-					if options.disableCaptchaBypass {
-						err0 := errors.New("infinite CAPTCHA detected; " +
-							"please resolve it manually on Bing's website or mobile client")
-						out <- Message{
-							Type:  MessageTypeError,
-							Text:  err0.Error(),
-							Error: err0,
-						}
-						return
-					}
-					slog.Info("Start to resolve the captcha", "server", o.bypassServer)
-					out <- Message{
-						Type: MessageTypeResolvingCaptcha,
-						Text: "Please wait patiently while we are resolving the CAPTCHA...",
-					}
-					if o.bypassServer == "" {
-						err = o.ResolveCaptcha(options.StopCtx)
-					} else {
-						err = o.BypassCaptcha(options.StopCtx, conversation.ConversationId,
-							options.messageID)
-					}
-					if err != nil {
-						if !errors.Is(err, context.Canceled) {
-							err = fmt.Errorf("cannot resolve CAPTCHA automatically; "+
-								"please resolve it manually on Bing's website or mobile client: %w", err)
-							out <- Message{
-								Type:  MessageTypeError,
-								Text:  err.Error(),
-								Error: err,
-							}
-						}
-						return
-					}
-					newOptions := options
-					newOptions.disableCaptchaBypass = true
-					newOptions.messageID = ""
-					newCh, err := o.AskStream(newOptions)
-					if err != nil {
-						out <- Message{
+			}
+			if errors.Is(msg.Error, ErrCaptchaRequired) {
+				if options.disableCaptchaBypass {
+					err0 := fmt.Errorf("%w: infinite CAPTCHA detected; "+
+						"please resolve it manually on Bing's website or mobile client", ErrCaptchaRequired)
+					emit(Message{
+						Type:  MessageTypeError,
+						Text:  err0.Error(),
+						Error: err0,
+					})
+					return
+				}
+				solver := o.captchaSolver
+				if solver == nil {
+					solver = util.Ternary[CaptchaSolver](o.bypassServer == "", BrowserCaptchaSolver{},
+						BypassServerCaptchaSolver{URL: o.bypassServer})
+				}
+				slog.Info("Start to resolve the captcha", "solver", fmt.Sprintf("%T", solver))
+				if !emit(Message{
+					Type: MessageTypeResolvingCaptcha,
+					Text: "Please wait patiently while we are resolving the CAPTCHA...",
+				}) {
+					return
+				}
+				err = o.solveCaptcha(options.StopCtx, solver, conversation.ConversationId, options.messageID)
+				if err != nil {
+					if !errors.Is(err, context.Canceled) {
+						err = fmt.Errorf("%w: cannot resolve CAPTCHA automatically; "+
+							"please resolve it manually on Bing's website or mobile client: %w", ErrCaptchaRequired, err)
+						emit(Message{
 							Type:  MessageTypeError,
 							Text:  err.Error(),
 							Error: err,
-						}
-						return
-					}
-					for newMsg := range newCh { // proxy messages from recursive AskStream
-						out <- newMsg
+						})
 					}
 					return
-				} else {
-					out <- Message{
+				}
+				newOptions := options
+				newOptions.disableCaptchaBypass = true
+				newOptions.messageID = ""
+				stopRaw() // the connection of the CAPTCHA-blocked answer is no longer needed
+				newCh, err := o.AskStream(newOptions)
+				if err != nil {
+					emit(Message{
 						Type:  MessageTypeError,
-						Text:  msg.Error.Error(),
-						Error: msg.Error,
-					}
+						Text:  err.Error(),
+						Error: err,
+					})
 					return
 				}
-			}
-			data := gjson.Parse(msg.Data)
-			if data.Get("type").Int() == 1 && data.Get("arguments.0.messages").Exists() {
-				message := data.Get("arguments.0.messages.0")
-				msgType := message.Get("messageType")
-				messageText := message.Get("text").String()
-				messageHiddenText := message.Get("hiddenText").String()
-				contentOrigin := message.Get("contentOrigin").String()
-				switch msgType.String() {
-				case "InternalSearchQuery":
-					out <- Message{
-						Type: MessageTypeSearchQuery,
-						Text: messageText,
-					}
-				case "InternalSearchResult":
-					if strings.Contains(messageHiddenText,
-						"Web search returned no relevant result") {
-						slog.Info("Web search returned no relevant result")
-						continue
-					}
-					if !gjson.Valid(messageText) {
-						slog.Error("Error when parsing InternalSearchResult", "messageText", messageText)
-						continue
-					}
-					arr := gjson.Parse(messageText).Array()
-					for _, group := range arr {
-						group.ForEach(func(key, value gjson.Result) bool {
-							for _, subGroup := range value.Array() {
-								sourceAttributes = append(sourceAttributes, SourceAttribute{
-									Link:  subGroup.Get("url").String(),
-									Title: subGroup.Get("title").String(),
-								})
-							}
-							return true
-						})
-					}
-				case "InternalLoaderMessage":
-					if contentOrigin == "retrieve-shortdoc-progress" || contentOrigin == "compress-longdoc-progress" {
-						docLoadingMessage := messageText + " " + messageHiddenText + " (" + contentOrigin + ")"
-						if tmpLastDocLoadingMessage == docLoadingMessage { // message is duplicate
-							continue
-						} else { // update new last message
-							tmpLastDocLoadingMessage = docLoadingMessage
-						}
-						out <- Message{
-							Type: MessageTypeLoading,
-							Text: docLoadingMessage,
-						}
-						continue
-					}
-					if message.Get("hiddenText").Exists() {
-						out <- Message{
-							Type: MessageTypeLoading,
-							Text: messageHiddenText,
-						}
-						continue
-					}
-					if message.Get("text").Exists() {
-						out <- Message{
-							Type: MessageTypeLoading,
-							Text: messageText,
-						}
-						continue
-					}
-					out <- Message{
-						Type: MessageTypeLoading,
-						Text: message.Raw,
-					}
-				case "GenerateContentQuery":
-					switch message.Get("contentType").String() {
-					case "IMAGE":
-						generativeImage := GenerativeImage{
-							Text: messageText,
-							URL: "https://www.bing.com/images/create?" +
-								"partner=sydney&re=1&showselective=1&sude=1&kseed=7500&SFX=2&gptexp=unknown" +
-								"&q=" + url.QueryEscape(messageText) + "&iframeid=" +
-								message.Get("messageId").String(),
-						}
-						v, err := json.Marshal(&generativeImage)
-						if err != nil {
-							util.GracefulPanic(err)
-						}
-						out <- Message{
-							Type: MessageTypeGenerativeImage,
-							Text: string(v),
-						}
-					case "SUNO":
-						generativeMusic := GenerativeMusic{
-							IFrameID:  message.Get("messageId").String(),
-							RequestID: strings.TrimPrefix(messageHiddenText, "RequestId="),
-							Text:      message.Get("invocation").String(),
-						}
-						v, err := json.Marshal(&generativeMusic)
-						if err != nil {
-							util.GracefulPanic(err)
-						}
-						out <- Message{
-							Type: MessageTypeGenerativeMusic,
-							Text: string(v),
-						}
-					default:
-						continue
-					}
-				case "Progress":
-					switch contentOrigin {
-					case "CodeInterpreter":
-						invocation := message.Get("invocation").String()
-						if invocation == "" {
-							continue
-						}
-						out <- Message{
-							Type: MessageTypeExecutingTask,
-							Text: invocation,
-						}
-					case "OpenAPI-spec":
-						text := message.Get("adaptiveCards.0.body.0.columns.0.items.0.text").String()
-						if text == "" {
-							continue
-						}
-						out <- Message{
-							Type: MessageTypeOpenAPICall,
-							Text: text,
-						}
-					default:
-						slog.Warn("Unsupported progress type",
-							"contentOrigin", contentOrigin,
-							"triggered-by", options.Prompt, "response", message.Raw)
-					}
-				case "GeneratedCode":
-					out <- Message{
-						Type: MessageTypeGeneratedCode,
-						Text: messageText,
+				for newMsg := range newCh { // proxy messages from recursive AskStream
+					if !emit(newMsg) {
+						return // the recursive AskStream stops as well, since it shares StopCtx
 					}
-				case "":
-					if data.Get("arguments.0.cursor").Exists() {
-						wrote = 0
-						// extract search result from text block
-						if text := strings.TrimSuffix(message.Get("adaptiveCards.0.body.0.text").String(),
-							messageText); strings.TrimSpace(text) != "" {
-							arr := lo.Filter(lo.Map(strings.Split(text, "\n"), func(item string, index int) string {
-								return strings.Trim(item, " \"")
-							}), func(item string, index int) bool {
-								return item != ""
-							})
-							re := regexp.MustCompile(`\[(\d+)]: (.*)`)
-							var resultSources []SourceAttribute
-							for _, line := range arr {
-								matches := re.FindStringSubmatch(line)
-								if len(matches) == 0 {
-									continue
-								}
-								ix := matches[1]
-								link := matches[2]
-								sourceAttribute, ok := lo.Find(sourceAttributes, func(item SourceAttribute) bool {
-									return item.Link == link
-								})
-								if !ok {
-									continue
-								}
-								sourceAttribute.Index, _ = strconv.Atoi(ix)
-								resultSources = append(resultSources, sourceAttribute)
-							}
-							var resultArr []string
-							for _, src := range resultSources {
-								v, _ := json.Marshal(&src)
-								resultArr = append(resultArr, "  "+string(v))
-							}
-							if len(resultArr) != 0 {
-								out <- Message{
-									Type: MessageTypeSearchResult,
-									Text: "[\n" + strings.Join(resultArr, ",\n") + "\n]",
-								}
-							}
-						}
-					}
-					if contentOrigin == "Apology" {
-						if wrote != 0 {
-							out <- Message{
-								Type:  MessageTypeError,
-								Text:  "Message revoke detected",
-								Error: ErrMessageRevoke,
-							}
-						} else {
-							out <- Message{
-								Type:  MessageTypeError,
-								Text:  "Looks like the user's message has triggered the Bing filter",
-								Error: ErrMessageFiltered,
-							}
-						}
-						return
-					} else {
-						if wrote < len(messageText) {
-							out <- Message{
-								Type: MessageTypeMessageText,
-								Text: messageText[wrote:],
-							}
-							wrote = len(messageText)
-						} else if wrote > len(messageText) { // Bing deletes some already sent text
-							wrote = len(messageText)
-						}
-						sendSuggestedResponses(message)
-					}
-				default:
-					slog.Warn("Unsupported message type",
-						"type", msgType.String(), "triggered-by", options.Prompt, "response", message.Raw)
 				}
-			} else if data.Get("type").Int() == 2 && data.Get("item.messages").Exists() {
-				message := data.Get("item.messages|@reverse|0")
-				sendSuggestedResponses(message)
+				return
+			}
+			for _, message := range decoder.Decode(msg) {
+				if message.Throttling != nil && options.turn != nil {
+					options.turn.session.setThrottling(*message.Throttling)
+				}
+				if !emit(message) {
+					return
+				}
+			}
+			if decoder.Finished() {
+				return
 			}
 		}
 	}(out, ch)
 	return out, nil
 }
 func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationResponse, <-chan RawMessage, error) {
-	slog.Info("AskStreamRaw called, creating conversation...")
-	conversation, err := o.createConversation()
-	if err != nil {
-		return CreateConversationResponse{}, nil, err
+	var conversation CreateConversationResponse
+	var err error
+	turn := 0
+	var pooledConn *Conn // a connection of the pooled conversation, if any
+	if options.turn != nil {
+		slog.Info("AskStreamRaw called within a session", "turn", options.turn.index)
+		conversation, turn = options.turn.conversation, options.turn.index
+	} else if entry, ok := o.takePooledConversation(); ok {
+		conversation, pooledConn = entry.conversation, entry.conn
+		slog.Info("AskStreamRaw called, conversation taken from the pool",
+			"conversation-id", conversation.ConversationId, "connected", pooledConn != nil)
+	} else {
+		slog.Info("AskStreamRaw called, creating conversation...")
+		conversation, err = o.createConversation(options.StopCtx)
+		if err != nil {
+			return CreateConversationResponse{}, nil, err
+		}
+		slog.Info("Conversation created", "conversation-id", conversation.ConversationId)
 	}
-	slog.Info("Conversation created", "conversation-id", conversation.ConversationId)
+	handedOver := false // whether the pooled connection is up to the goroutine
+	defer func() {
+		if pooledConn != nil && !handedOver {
+			pooledConn.CloseNow()
+		}
+	}()
 	select {
 	case <-options.StopCtx.Done():
 		return conversation, nil, options.StopCtx.Err()
 	default:
 	}
-	previousMessages := []PreviousMessage{
-		{
+	isStartOfSession := turn == 0
+	previousMessages := []PreviousMessage{}
+	if len(options.History) != 0 {
+		previousMessages = append(previousMessages, previousMessagesFromHistory(options.History)...)
+	} else if isStartOfSession || options.WebpageContext != "" {
+		previousMessages = append(previousMessages, PreviousMessage{
 			Author:      "user",
 			Description: options.WebpageContext,
 			ContextType: "WebPage",
 			MessageType: "Context",
-		},
+		})
 	}
+	conversationOptions := o.requestConversationOptions(options)
 	var uploadFileResult UploadFileResult
 	if options.UploadFilePath != "" {
 		slog.Info("Invoke file upload", "path", options.UploadFilePath)
-		uploadFileResult, err = o.uploadFile(options.UploadFilePath, conversation)
+		uploadFileResult, err = o.uploadFile(options.StopCtx, options.UploadFilePath, conversation, conversationOptions.tone)
 		if err != nil {
 			return CreateConversationResponse{}, nil, err
 		}
@@ -364,100 +194,46 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 		})
 	}
 	msgChan := make(chan RawMessage)
+	handedOver = true
 	go func(msgChan chan RawMessage) {
 		defer func(msgChan chan RawMessage) {
 			slog.Info("AskStreamRaw is closing raw message channel")
 			close(msgChan)
 		}(msgChan)
-		client, _, err := util.MakeHTTPClient(o.proxy, 0)
-		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
-			return
+		if pooledConn != nil {
+			defer pooledConn.CloseNow()
 		}
 		messageID := options.messageID
 		if messageID == "" {
 			msgID, err := uuid.NewUUID()
 			if err != nil {
-				msgChan <- RawMessage{
+				send(options.StopCtx, msgChan, RawMessage{
 					Error: err,
-				}
+				})
 				return
 			}
 			messageID = msgID.String()
 		}
-		httpHeaders := http.Header{}
-		for k, v := range o.headers() {
-			httpHeaders.Set(k, v)
-		}
-		ctx, cancel := util.CreateTimeoutContext(10 * time.Second)
-		defer cancel()
-		connRaw, resp, err := websocket.Dial(ctx,
-			o.wssURL+util.Ternary(conversation.SecAccessToken != "", "?sec_access_token="+
-				url.QueryEscape(conversation.SecAccessToken), ""),
-			&websocket.DialOptions{
-				HTTPClient: client,
-				HTTPHeader: httpHeaders,
-			})
-		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
-			return
-		}
-		if resp.StatusCode != 101 {
-			msgChan <- RawMessage{
-				Error: errors.New("cannot establish a websocket connection"),
-			}
-			return
-		}
-		defer connRaw.CloseNow()
-		select {
-		case <-options.StopCtx.Done():
-			slog.Info("Exit askStream because of received signal from stopCtx")
-			return
-		default:
-		}
-		connRaw.SetReadLimit(-1)
-		conn := &Conn{Conn: connRaw, debug: o.debug}
-		err = conn.WriteWithTimeout([]byte(`{"protocol": "json", "version": 1}`))
-		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
-			return
-		}
-		conn.ReadWithTimeout()
-		err = conn.WriteWithTimeout([]byte(`{"type": 6}`))
-		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
-			return
-		}
 		chatMessage := ChatMessage{
 			Arguments: []Argument{
 				{
-					OptionsSets:         o.optionsSet,
-					Source:              "cib-ccp",
+					OptionsSets:         conversationOptions.optionsSet,
+					Source:              o.profile.Source,
 					AllowedMessageTypes: o.allowedMessageTypes,
 					SliceIds:            o.sliceIDs,
 					Verbosity:           "verbose",
-					Scenario:            "SERP",
-					Plugins:             o.plugins,
+					Scenario:            o.profile.Scenario,
+					Plugins:             conversationOptions.plugins,
 					TraceId:             util.MustGenerateRandomHex(16),
 					RequestId:           messageID,
-					IsStartOfSession:    true,
+					IsStartOfSession:    isStartOfSession,
 					Message: ArgumentMessage{
-						Locale: o.locale,
-						Market: o.locale,
-						Region: "US",
-						Location: fmt.Sprintf("lat:%.6f;long:%.6f;re=1000m;",
-							o.locationHint.Center.Latitude,
-							o.locationHint.Center.Longitude),
+						Locale:   conversationOptions.locale,
+						Market:   conversationOptions.location.Market,
+						Region:   conversationOptions.location.Region,
+						Location: conversationOptions.location.locationString(),
 						LocationHints: []LocationHint{
-							o.locationHint,
+							conversationOptions.location.locationHint(),
 						},
 						AttachedFilesInfos: lo.Ternary(uploadFileResult.Valid, []ArgumentAttachedFilesInfo{
 							{
@@ -473,78 +249,142 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 						MessageId:   messageID,
 						ImageUrl:    util.Ternary[any](options.ImageURL == "", nil, options.ImageURL),
 					},
-					Tone: o.conversationStyle,
+					Tone: conversationOptions.tone,
 					ConversationSignature: util.Ternary[any](conversation.ConversationSignature == "",
 						nil, conversation.ConversationSignature),
 					Participant:      Participant{Id: conversation.ClientId},
 					SpokenTextMode:   "None",
 					ConversationId:   conversation.ConversationId,
 					PreviousMessages: previousMessages,
-					GptId:            o.gptID,
+					GptId:            conversationOptions.gptID,
 				},
 			},
-			InvocationId: "0",
+			InvocationId: strconv.Itoa(turn),
 			Target:       "chat",
 			Type:         4,
 		}
 		chatMessageV, err := json.Marshal(&chatMessage)
 		if err != nil {
-			msgChan <- RawMessage{
+			send(options.StopCtx, msgChan, RawMessage{
 				Error: err,
-			}
+			})
 			return
 		}
-		err = conn.WriteWithTimeout(chatMessageV)
-		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
+		// reading and writing are canceled by StopCtx
+		answerCtx, cancel := withTimeout(options.StopCtx, o.timeouts.Total)
+		defer cancel()
+		answerError := func(err error) error {
+			return timeoutError(err, answerCtx, options.StopCtx, TimeoutTotal, o.timeouts.Total)
+		}
+		var conn *Conn
+		defer func() {
+			if conn != nil {
+				conn.CloseNow()
+			}
+		}()
+		if pooledConn != nil {
+			if err := pooledConn.WriteWithTimeout(answerCtx, chatMessageV); err == nil {
+				conn = pooledConn
+			} else {
+				slog.Warn("The pooled ChatHub connection is broken, reconnecting", "err", err)
 			}
-			return
 		}
-		for {
+		if conn == nil {
+			conn, err = retry(answerCtx, o.retryPolicy, "connect to ChatHub", func() (*Conn, error) {
+				return o.connectChatHub(answerCtx, conversation)
+			})
+			if err != nil {
+				send(options.StopCtx, msgChan, RawMessage{
+					Error: answerError(err),
+				})
+				return
+			}
 			select {
 			case <-options.StopCtx.Done():
 				slog.Info("Exit askStream because of received signal from stopCtx")
 				return
 			default:
 			}
-			messages, err := conn.ReadWithTimeout()
+			err = conn.WriteWithTimeout(answerCtx, chatMessageV)
 			if err != nil {
-				msgChan <- RawMessage{
-					Error: err,
-				}
+				send(options.StopCtx, msgChan, RawMessage{
+					Error: answerError(err),
+				})
 				return
 			}
-			if time.Now().Unix()%6 == 0 {
-				err = conn.WriteWithTimeout([]byte(`{"type": 6}`))
-				if err != nil {
-					msgChan <- RawMessage{
+		}
+		if options.turn != nil {
+			options.turn.session.turnSent(turn)
+		}
+		reconnects := 0
+		for {
+			select {
+			case <-options.StopCtx.Done():
+				slog.Info("Exit askStream because of received signal from stopCtx")
+				return
+			default:
+			}
+			messages, err := conn.ReadWithTimeout(answerCtx)
+			if err != nil {
+				if options.StopCtx.Err() != nil {
+					slog.Info("Exit askStream because of received signal from stopCtx")
+					return
+				}
+				err = answerError(err)
+				if !isResumable(err) {
+					send(options.StopCtx, msgChan, RawMessage{
 						Error: err,
-					}
+					})
 					return
 				}
+				if reconnects >= o.reconnectAttempts {
+					send(options.StopCtx, msgChan, RawMessage{
+						Error: fmt.Errorf("%w: %w", ErrAnswerInterrupted, err),
+					})
+					return
+				}
+				reconnects++
+				slog.Warn("ChatHub connection lost, reconnecting", "err", err,
+					"attempt", reconnects, "max", o.reconnectAttempts)
+				if !send(options.StopCtx, msgChan, RawMessage{
+					Reconnecting: true,
+				}) {
+					return
+				}
+				// the question is not asked again, which would be a new turn of the conversation;
+				// Bing streams the rest of the answer on the new connection, or it is interrupted
+				conn.CloseNow()
+				conn, err = o.connectChatHub(answerCtx, conversation)
+				if err != nil {
+					send(options.StopCtx, msgChan, RawMessage{
+						Error: fmt.Errorf("%w: %w", ErrAnswerInterrupted, answerError(err)),
+					})
+					return
+				}
+				continue
 			}
 			for _, msg := range messages {
 				if msg == "" {
 					continue
 				}
 				if !gjson.Valid(msg) {
-					msgChan <- RawMessage{
+					send(options.StopCtx, msgChan, RawMessage{
 						Error: errors.New("malformed json"),
-					}
+					})
 					return
 				}
 				result := gjson.Parse(msg)
 				if result.Get("type").Int() == 2 && result.Get("item.result.value").String() != "Success" {
-					msgChan <- RawMessage{
-						Error: errors.New("bing explicit error: value: " +
-							result.Get("item.result.value").String() + "; message: " +
+					send(options.StopCtx, msgChan, RawMessage{
+						Error: newResultError(result.Get("item.result.value").String(),
 							result.Get("item.result.message").String()),
-					}
+					})
 					return
 				}
-				msgChan <- RawMessage{
+				if !send(options.StopCtx, msgChan, RawMessage{
 					Data: msg,
+				}) {
+					return
 				}
 				if result.Get("type").Int() == 2 {
 					// finish the conversation
@@ -555,3 +395,66 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 	}(msgChan)
 	return conversation, msgChan, nil
 }
+
+// connectChatHub dials the ChatHub websocket of the conversation, finishes the handshake
+// and starts pinging the server.
+func (o *Sydney) connectChatHub(parent context.Context, conversation CreateConversationResponse) (*Conn, error) {
+	client, _, err := o.makeHTTPClient(0)
+	if err != nil {
+		return nil, err
+	}
+	httpHeaders := http.Header{}
+	for k, v := range o.headers() {
+		httpHeaders.Set(k, v)
+	}
+	ctx, cancel := withTimeout(parent, o.timeouts.Dial)
+	defer cancel()
+	dialError := func(err error) error {
+		return timeoutError(err, ctx, parent, TimeoutDial, o.timeouts.Dial)
+	}
+	connRaw, resp, err := websocket.Dial(ctx,
+		o.endpoints.ChatHub+util.Ternary(conversation.SecAccessToken != "", "?sec_access_token="+
+			url.QueryEscape(conversation.SecAccessToken), ""),
+		&websocket.DialOptions{
+			HTTPClient: client,
+			HTTPHeader: httpHeaders,
+		})
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 500 {
+			return nil, errors.Join(newStatusError("connect to ChatHub", resp.StatusCode), err)
+		}
+		return nil, dialError(err)
+	}
+	if resp.StatusCode != 101 {
+		connRaw.CloseNow()
+		return nil, errors.New("cannot establish a websocket connection")
+	}
+	connRaw.SetReadLimit(-1)
+	conn := &Conn{Conn: connRaw, debug: o.debug, timeouts: o.timeouts}
+	err = conn.WriteWithTimeout(ctx, []byte(`{"protocol": "json", "version": 1}`))
+	if err != nil {
+		conn.CloseNow()
+		return nil, dialError(err)
+	}
+	if _, err := conn.ReadWithTimeout(ctx); err != nil {
+		conn.CloseNow()
+		return nil, dialError(err)
+	}
+	err = conn.WriteWithTimeout(ctx, []byte(`{"type": 6}`))
+	if err != nil {
+		conn.CloseNow()
+		return nil, dialError(err)
+	}
+	conn.keepalive(o.timeouts.Keepalive)
+	return conn, nil
+}
+
+// isResumable reports whether the read error is caused by a broken connection rather than
+// a close frame sent by the server, so that the answer can be resumed on a new connection.
+func isResumable(err error) bool {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) && timeoutErr.Op == TimeoutTotal {
+		return false
+	}
+	return websocket.CloseStatus(err) == -1
+}
@@ -0,0 +1,402 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sydneyqt/sydney/sydneytest"
+	"sydneyqt/util"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func newFakeSydney(server *sydneytest.Server) *Sydney {
+	return NewSydney(Options{
+		Cookies:   map[string]string{"_U": "fake"},
+		Endpoints: LocalEndpoints(server.URL),
+	})
+}
+
+func collect(ch <-chan Message) []Message {
+	var messages []Message
+	for msg := range ch {
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func messageText(messages []Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		if msg.Type == MessageTypeMessageText {
+			sb.WriteString(msg.Text)
+		}
+	}
+	return sb.String()
+}
+
+func TestAskStream(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	syd := newFakeSydney(server)
+	ask := func(options AskStreamOptions) []Message {
+		options.StopCtx = context.Background()
+		ch, err := syd.AskStream(options)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return collect(ch)
+	}
+	t.Run("streaming text", func(t *testing.T) {
+		server.Enqueue(sydneytest.TextScenario("Hello", ", world", "!"))
+		messages := ask(AskStreamOptions{Prompt: "hi", WebpageContext: "[system](#instructions)\nBe nice."})
+		assert.Equal(t, "Hello, world!", messageText(messages))
+		last := messages[len(messages)-1]
+		assert.Equal(t, MessageTypeSuggestedResponses, last.Type)
+		assert.Equal(t, []string{"Tell me more.", "Thanks!"}, last.Suggestions)
+		requests := server.ChatRequests()
+		request := gjson.Parse(requests[len(requests)-1])
+		assert.Equal(t, "hi", request.Get("arguments.0.message.text").String())
+		assert.True(t, request.Get("arguments.0.isStartOfSession").Bool())
+		assert.Equal(t, "[system](#instructions)\nBe nice.",
+			request.Get("arguments.0.previousMessages.0.description").String())
+	})
+	t.Run("search results", func(t *testing.T) {
+		server.Enqueue(sydneytest.SearchScenario("news", []sydneytest.Source{
+			{Title: "Example", URL: "https://example.com"},
+		}, "Here is the news", "[^1^]."))
+		messages := ask(AskStreamOptions{Prompt: "news"})
+		searchResult, ok := findMessage(messages, MessageTypeSearchResult)
+		assert.True(t, ok)
+		assert.Equal(t, []SourceAttribute{{Index: 1, Link: "https://example.com", Title: "Example"}},
+			searchResult.Sources)
+		assert.Equal(t, "Here is the news[^1^].", messageText(messages))
+	})
+	t.Run("message revoke", func(t *testing.T) {
+		server.Enqueue(sydneytest.ApologyScenario("Well, "))
+		messages := ask(AskStreamOptions{Prompt: "hi"})
+		last := messages[len(messages)-1]
+		assert.True(t, errors.Is(last.Error, ErrMessageRevoke))
+	})
+	t.Run("message filtered", func(t *testing.T) {
+		server.Enqueue(sydneytest.ApologyScenario())
+		messages := ask(AskStreamOptions{Prompt: "hi"})
+		last := messages[len(messages)-1]
+		assert.True(t, errors.Is(last.Error, ErrMessageFiltered))
+	})
+	t.Run("captcha", func(t *testing.T) {
+		server.Enqueue(sydneytest.CaptchaScenario())
+		messages := ask(AskStreamOptions{Prompt: "hi", disableCaptchaBypass: true})
+		last := messages[len(messages)-1]
+		assert.Equal(t, MessageTypeError, last.Type)
+		assert.Contains(t, last.Text, "CAPTCHA")
+	})
+	t.Run("throttled", func(t *testing.T) {
+		server.Enqueue(sydneytest.ThrottledScenario())
+		messages := ask(AskStreamOptions{Prompt: "hi"})
+		last := messages[len(messages)-1]
+		assert.Equal(t, MessageTypeError, last.Type)
+		assert.Contains(t, last.Text, "Throttled")
+	})
+	t.Run("reconnect", func(t *testing.T) {
+		server.Enqueue(sydneytest.ResumeScenario(2, "Hello", ", world", "!"))
+		requests := len(server.ChatRequests())
+		messages := ask(AskStreamOptions{Prompt: "hi"})
+		_, ok := findMessage(messages, MessageTypeReconnecting)
+		assert.True(t, ok)
+		assert.Equal(t, "Hello, world!", messageText(messages))
+		assert.Len(t, server.ChatRequests(), requests+1) // not asked again
+	})
+	t.Run("reconnect not resumed", func(t *testing.T) {
+		syd := NewSydney(Options{
+			Cookies:           map[string]string{"_U": "fake"},
+			Endpoints:         LocalEndpoints(server.URL),
+			ReconnectAttempts: 1,
+			Timeouts:          Timeouts{ReadIdle: 200 * time.Millisecond},
+		})
+		server.Enqueue(sydneytest.DropScenario("Hello"))
+		requests := len(server.ChatRequests())
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		messages := collect(ch)
+		assert.Equal(t, "Hello", messageText(messages))
+		last := messages[len(messages)-1]
+		assert.Equal(t, MessageTypeError, last.Type)
+		assert.ErrorIs(t, last.Error, ErrAnswerInterrupted)
+		assert.Len(t, server.ChatRequests(), requests+1)
+	})
+	t.Run("reconnect disabled", func(t *testing.T) {
+		syd := NewSydney(Options{
+			Cookies:           map[string]string{"_U": "fake"},
+			Endpoints:         LocalEndpoints(server.URL),
+			ReconnectAttempts: -1,
+		})
+		server.Enqueue(sydneytest.DropScenario("Hello"))
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		messages := collect(ch)
+		_, ok := findMessage(messages, MessageTypeReconnecting)
+		assert.False(t, ok)
+		assert.Equal(t, MessageTypeError, messages[len(messages)-1].Type)
+		assert.ErrorIs(t, messages[len(messages)-1].Error, ErrAnswerInterrupted)
+	})
+	t.Run("chat options override", func(t *testing.T) {
+		chatOptions := syd.ChatOptions()
+		chatOptions.ConversationStyle = "Precise"
+		chatOptions.NoSearch = true
+		chatOptions.Locale = "zh-CN"
+		for _, options := range []*ChatOptions{&chatOptions, nil} {
+			server.Enqueue(sydneytest.TextScenario("Hello"))
+			ask(AskStreamOptions{Prompt: "hi", ChatOptions: options})
+		}
+		requests := server.ChatRequests()
+		overridden := gjson.Parse(requests[len(requests)-2]).Get("arguments.0")
+		assert.Equal(t, "Precise", overridden.Get("tone").String())
+		assert.Equal(t, "zh-CN", overridden.Get("message.locale").String())
+		assert.Contains(t, overridden.Get("optionsSets").String(), `"nosearchall"`)
+		assert.Contains(t, overridden.Get("optionsSets").String(), `"h3precise"`)
+		def := gjson.Parse(requests[len(requests)-1]).Get("arguments.0")
+		assert.Equal(t, "Creative", def.Get("tone").String())
+		assert.Equal(t, "en-US", def.Get("message.locale").String())
+		assert.NotContains(t, def.Get("optionsSets").String(), `"nosearchall"`)
+	})
+	t.Run("conversation creation failure", func(t *testing.T) {
+		server.SetCreateConversationStatus(500)
+		defer server.SetCreateConversationStatus(0)
+		_, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	ask := func(timeouts Timeouts, scenario sydneytest.Scenario) []Message {
+		syd := NewSydney(Options{
+			Cookies:           map[string]string{"_U": "fake"},
+			Endpoints:         LocalEndpoints(server.URL),
+			ReconnectAttempts: -1,
+			Timeouts:          timeouts,
+		})
+		server.Enqueue(scenario)
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return collect(ch)
+	}
+	slowScenario := func(delay time.Duration) sydneytest.Scenario {
+		scenario := sydneytest.TextScenario("Hello", ", world")
+		scenario.Frames[1].Delay = delay
+		return scenario
+	}
+	t.Run("keepalive while waiting", func(t *testing.T) {
+		pings := server.Pings()
+		messages := ask(Timeouts{ReadIdle: time.Second, Keepalive: 50 * time.Millisecond},
+			slowScenario(400*time.Millisecond))
+		assert.Equal(t, "Hello, world", messageText(messages))
+		assert.GreaterOrEqual(t, server.Pings()-pings, 5)
+	})
+	t.Run("read idle", func(t *testing.T) {
+		messages := ask(Timeouts{ReadIdle: 100 * time.Millisecond}, slowScenario(time.Second))
+		last := messages[len(messages)-1]
+		var timeoutErr *TimeoutError
+		if assert.ErrorAs(t, last.Error, &timeoutErr) {
+			assert.Equal(t, TimeoutReadIdle, timeoutErr.Op)
+			assert.Equal(t, 100*time.Millisecond, timeoutErr.Timeout)
+		}
+		assert.Equal(t, "Hello", messageText(messages))
+	})
+	t.Run("total", func(t *testing.T) {
+		messages := ask(Timeouts{Total: 200 * time.Millisecond, Keepalive: 50 * time.Millisecond},
+			slowScenario(time.Second))
+		var timeoutErr *TimeoutError
+		if assert.ErrorAs(t, messages[len(messages)-1].Error, &timeoutErr) {
+			assert.Equal(t, TimeoutTotal, timeoutErr.Op)
+		}
+	})
+	t.Run("server close is not a timeout", func(t *testing.T) {
+		messages := ask(Timeouts{}, sydneytest.DropScenario("Hello"))
+		var timeoutErr *TimeoutError
+		assert.False(t, errors.As(messages[len(messages)-1].Error, &timeoutErr))
+	})
+}
+
+// askStreamGoroutines returns the number of goroutines started by AskStream and AskStreamRaw.
+func askStreamGoroutines() int {
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+	return strings.Count(stacks, "sydney.(*Sydney).AskStream") + strings.Count(stacks, "sydney.(*Conn).keepalive")
+}
+
+func TestAskStreamLeak(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	syd := newFakeSydney(server)
+	noLeak := func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			return askStreamGoroutines() == 0
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+	t.Run("consumer stops reading", func(t *testing.T) {
+		server.Enqueue(sydneytest.TextScenario("Hello", ", world", "!"))
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: ctx, Prompt: "hi"})
+		assert.Nil(t, err)
+		<-ch
+		cancel()
+		noLeak(t)
+	})
+	t.Run("stopped while waiting", func(t *testing.T) {
+		scenario := sydneytest.TextScenario("Hello", ", world")
+		scenario.Frames[1].Delay = 10 * time.Second
+		server.Enqueue(scenario)
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: ctx, Prompt: "hi"})
+		assert.Nil(t, err)
+		<-ch
+		cancel()
+		noLeak(t)
+	})
+	t.Run("answer over while the connection is open", func(t *testing.T) {
+		server.Enqueue(sydneytest.ApologyScenario("Well, "))
+		ch, err := syd.AskStream(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		collect(ch)
+		noLeak(t)
+	})
+}
+
+func TestSession(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	session := newFakeSydney(server).NewSession()
+	for i, prompt := range []string{"first", "second"} {
+		ch, err := session.Ask(AskStreamOptions{
+			StopCtx:        context.Background(),
+			Prompt:         prompt,
+			WebpageContext: util.Ternary(i == 0, "[system](#instructions)\nBe nice.", ""),
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello, this is Bing.", messageText(collect(ch)))
+	}
+	assert.Equal(t, 1, server.Conversations())
+	assert.Equal(t, 2, session.Turns())
+	requests := server.ChatRequests()
+	assert.Len(t, requests, 2)
+	first, second := gjson.Parse(requests[0]), gjson.Parse(requests[1])
+	assert.True(t, first.Get("arguments.0.isStartOfSession").Bool())
+	assert.False(t, second.Get("arguments.0.isStartOfSession").Bool())
+	assert.Equal(t, "0", first.Get("invocationId").String())
+	assert.Equal(t, "1", second.Get("invocationId").String())
+	assert.Equal(t, first.Get("arguments.0.conversationId").String(),
+		second.Get("arguments.0.conversationId").String())
+	assert.Len(t, second.Get("arguments.0.previousMessages").Array(), 0)
+	throttling, ok := session.Throttling()
+	assert.True(t, ok)
+	assert.Equal(t, 2, throttling.NumUserMessagesInConversation)
+}
+
+func TestSessionRetry(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	session := NewSydney(Options{
+		Cookies:     map[string]string{"_U": "fake"},
+		Endpoints:   LocalEndpoints(server.URL),
+		RetryPolicy: RetryPolicy{MaxAttempts: 1},
+	}).NewSession()
+	ask := func() []Message {
+		ch, err := session.Ask(AskStreamOptions{
+			StopCtx:        context.Background(),
+			Prompt:         "hi",
+			WebpageContext: "[system](#instructions)\nBe nice.",
+		})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return collect(ch)
+	}
+	server.SetChatHubStatus(502)
+	_, failed := findMessage(ask(), MessageTypeError)
+	assert.True(t, failed)
+	assert.Equal(t, 0, session.Turns())
+	server.SetChatHubStatus(0)
+	assert.Equal(t, "Hello, this is Bing.", messageText(ask()))
+	assert.Equal(t, 1, session.Turns())
+	requests := server.ChatRequests()
+	if assert.Len(t, requests, 1) {
+		request := gjson.Parse(requests[0])
+		assert.True(t, request.Get("arguments.0.isStartOfSession").Bool())
+		assert.Equal(t, "0", request.Get("invocationId").String())
+		assert.Equal(t, "[system](#instructions)\nBe nice.",
+			request.Get("arguments.0.previousMessages.0.description").String())
+	}
+}
+
+func TestSessionBusy(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	session := newFakeSydney(server).NewSession()
+	ch, err := session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "first"})
+	assert.Nil(t, err)
+	_, err = session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "second"})
+	assert.ErrorIs(t, err, ErrSessionBusy)
+	collect(ch)
+	ch, err = session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "second"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, this is Bing.", messageText(collect(ch)))
+	assert.Equal(t, 2, session.Turns())
+}
+
+func TestSessionCaptcha(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	server.Enqueue(sydneytest.CaptchaScenario(), sydneytest.TextScenario("Solved"))
+	session := NewSydney(Options{
+		Cookies:       map[string]string{"_U": "fake"},
+		Endpoints:     LocalEndpoints(server.URL),
+		CaptchaSolver: &stubCaptchaSolver{cookies: map[string]string{"cct": "solved"}},
+	}).NewSession()
+	ch, err := session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Solved", messageText(collect(ch)))
+	assert.Equal(t, 1, session.Turns())
+	requests := server.ChatRequests()
+	if assert.Len(t, requests, 2) { // the same turn asked again
+		for _, request := range requests {
+			assert.True(t, gjson.Get(request, "arguments.0.isStartOfSession").Bool())
+			assert.Equal(t, "0", gjson.Get(request, "invocationId").String())
+		}
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	server.SetMaxUserMessages(2)
+	session := newFakeSydney(server).NewSession()
+	for i := 0; i < 2; i++ {
+		ch, err := session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+		assert.Nil(t, err)
+		throttling, ok := findMessage(collect(ch), MessageTypeThrottling)
+		assert.True(t, ok)
+		assert.Equal(t, 1-i, throttling.Throttling.RemainingUserMessages())
+	}
+	assert.True(t, session.LimitReached())
+	_, err := session.Ask(AskStreamOptions{StopCtx: context.Background(), Prompt: "hi"})
+	assert.ErrorIs(t, err, ErrTurnLimitReached)
+	assert.Len(t, server.ChatRequests(), 2)
+}
+
+func findMessage(messages []Message, typ string) (Message, bool) {
+	for _, msg := range messages {
+		if msg.Type == typ {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
@@ -3,41 +3,103 @@ package sydney
 import (
 	"github.com/samber/lo"
 	"log/slog"
-	"strconv"
 	"sydneyqt/util"
+	"sync"
 
 	"github.com/google/uuid"
 	clone "github.com/huandu/go-clone/generic"
 )
 
 type Sydney struct {
-	debug                 bool
-	proxy                 string
-	conversationStyle     string
-	locale                string
-	wssURL                string
-	createConversationURL string
-	bypassServer          string
+	debug             bool
+	proxy             string
+	endpoints         Endpoints
+	bypassServer      string
+	reconnectAttempts int
+	timeouts          Timeouts
+	retryPolicy       RetryPolicy
+	pool              *ConversationPool
+	onCookiesUpdated  func(cookies map[string]string)
+	captchaSolver     CaptchaSolver
 
-	optionsSet          []string
+	chatOptions         ChatOptions
+	conversationOptions conversationOptions
 	sliceIDs            []string
-	locationHint        LocationHint
 	allowedMessageTypes []string
 	headers             func() map[string]string
+	profile             ClientProfile
+	cookiesMu           sync.Mutex
 	cookies             map[string]string
-	gptID               string
-	plugins             []ArgumentPlugin
 }
 
 func NewSydney(options Options) *Sydney {
+	pool := options.ConversationPool
+	options.ConversationPool = nil // shared, and referencing Sydney instances
 	debugOptions := clone.Clone(options)
 	debugOptions.Cookies = nil
+	options.ConversationPool = pool
 	slog.Info("New Sydney", "v", debugOptions)
 
 	uuidObj, err := uuid.NewUUID()
 	if err != nil {
 		util.GracefulPanic(err)
 	}
+	profileName := util.Ternary(options.ClientProfile == "", ClientProfileEdgeDesktop, options.ClientProfile)
+	profile, ok := findClientProfile(profileName, options.CustomClientProfiles)
+	if !ok {
+		slog.Warn("Client profile not found", "param", profileName, "fallback-to", ClientProfileEdgeDesktop)
+		profile, _ = findClientProfile(ClientProfileEdgeDesktop, nil)
+	}
+	forwardedIP := profile.forwardedIP()
+	cookies := util.Ternary(options.Cookies == nil, map[string]string{}, options.Cookies)
+	chatOptions := options.chatOptions()
+	conversationOptions := computeConversationOptions(chatOptions)
+	var o *Sydney
+	o = &Sydney{
+		debug:               options.Debug,
+		proxy:               options.Proxy,
+		endpoints:           options.endpoints(),
+		bypassServer:        options.BypassServer,
+		reconnectAttempts:   max(util.Ternary(options.ReconnectAttempts == 0, 2, options.ReconnectAttempts), 0),
+		timeouts:            options.Timeouts.withDefaults(),
+		retryPolicy:         options.RetryPolicy.withDefaults(),
+		pool:                options.ConversationPool,
+		onCookiesUpdated:    options.OnCookiesUpdated,
+		captchaSolver:       options.CaptchaSolver,
+		chatOptions:         chatOptions,
+		conversationOptions: conversationOptions,
+		sliceIDs:            []string{},
+		allowedMessageTypes: []string{
+			"ActionRequest",
+			"Chat",
+			"Context",
+			"InternalSearchQuery",
+			"InternalSearchResult",
+			"InternalLoaderMessage",
+			"Progress",
+			"GenerateContentQuery",
+			"SearchQuery",
+			"GeneratedCode",
+		},
+		headers: func() map[string]string {
+			headers := util.CopyMap(profile.Headers)
+			headers["sec-ms-gec"] = util.GenerateSecMSGec()
+			headers["x-ms-client-request-id"] = uuidObj.String()
+			if forwardedIP != "" {
+				headers["x-forwarded-for"] = forwardedIP
+			}
+			headers["Cookie"] = o.cookieString()
+			return headers
+		},
+		profile: profile,
+		cookies: cookies,
+	}
+	return o
+}
+
+// computeConversationOptions computes the options sets, tone and plugins sent to Bing from the
+// chat options. It runs for every request whose AskStreamOptions override the chat options.
+func computeConversationOptions(options ChatOptions) conversationOptions {
 	optionsSet := []string{
 		"fluxcopilot",
 		"nojbf", // no jailbreak filter
@@ -60,28 +122,25 @@ func NewSydney(options Options) *Sydney {
 		"ldsummary",   // our guess: long document summary
 		"ldqa",        // our guess: long document quality assurance
 	}
-	forwardedIP := "1.0.0." + strconv.Itoa(util.RandIntInclusive(1, 255))
-	cookies := util.Ternary(options.Cookies == nil, map[string]string{}, options.Cookies)
-	options.ConversationStyle = lo.Ternary(options.ConversationStyle == "",
-		"Creative", options.ConversationStyle)
+	tone := lo.Ternary(options.ConversationStyle == "", "Creative", options.ConversationStyle)
 	gptID := "copilot"
-	switch options.ConversationStyle {
+	switch tone {
 	case "Balanced":
 		optionsSet = append(optionsSet, "galileo", "gldcl1p")
 	case "Precise":
 		optionsSet = append(optionsSet, "h3precise")
 	case "Creative":
 		if options.UseClassic {
-			options.ConversationStyle = "CreativeClassic"
+			tone = "CreativeClassic"
 		}
 	case "Designer":
 		optionsSet = append(optionsSet, "ai_persona_designer_gpt")
-		options.ConversationStyle = "Creative"
+		tone = "Creative"
 		gptID = "designer"
 	default:
-		slog.Warn("Conversation style not found", "param", options.ConversationStyle,
+		slog.Warn("Conversation style not found", "param", tone,
 			"fallback-to", "Creative")
-		options.ConversationStyle = "Creative"
+		tone = "Creative"
 	}
 	if options.NoSearch && len(options.Plugins) == 0 {
 		optionsSet = append(optionsSet, "nosearchall")
@@ -104,81 +163,54 @@ func NewSydney(options Options) *Sydney {
 		optionsSet = append(optionsSet, plugin.OptionsSets...)
 		plugins = append(plugins, plugin.ArgumentPlugin)
 	}
-	slog.Info("Final conversation options", "options", optionsSet, "tone", options.ConversationStyle)
-	return &Sydney{
-		debug:             options.Debug,
-		proxy:             options.Proxy,
-		conversationStyle: options.ConversationStyle,
-		locale:            util.Ternary(options.Locale == "", "en-US", options.Locale),
-		wssURL: util.Ternary(options.WssDomain == "", "wss://sydney.bing.com/sydney/ChatHub",
-			"wss://"+options.WssDomain+"/sydney/ChatHub"),
-		createConversationURL: util.Ternary(options.CreateConversationURL == "",
-			"https://edgeservices.bing.com/edgesvc/turing/conversation/create", options.CreateConversationURL),
-		bypassServer: options.BypassServer,
-		optionsSet:   optionsSet,
-		sliceIDs:     []string{},
-		locationHint: LocationHint{
-			SourceType: 1,
-			RegionType: 2,
-			Center: LatLng{
-				Latitude:  33.97570037841797,
-				Longitude: -118.25640106201172,
-			},
-			Radius:                   24902,
-			Name:                     "Los Angeles, California",
-			Accuracy:                 24902,
-			FDConfidence:             0.5,
-			CountryName:              "United States",
-			CountryConfidence:        8,
-			Admin1Name:               "California",
-			PopulatedPlaceName:       "Los Angeles",
-			PopulatedPlaceConfidence: 5,
-			PostCodeName:             "90060",
-			UtcOffset:                -8,
-			Dma:                      803,
-		},
-		allowedMessageTypes: []string{
-			"ActionRequest",
-			"Chat",
-			"Context",
-			"InternalSearchQuery",
-			"InternalSearchResult",
-			"InternalLoaderMessage",
-			"Progress",
-			"GenerateContentQuery",
-			"SearchQuery",
-			"GeneratedCode",
-		},
-		headers: func() map[string]string {
-			return map[string]string{
-				"accept":                      "application/json",
-				"accept-language":             "en-US,en;q=0.9",
-				"content-type":                "application/json",
-				"sec-ch-ua":                   `"Microsoft Edge";v="113", "Chromium";v="113", "Not-A.Brand";v="24"`,
-				"sec-ch-ua-arch":              `"x86"`,
-				"sec-ch-ua-bitness":           `"64"`,
-				"sec-ch-ua-full-version":      `"113.0.1774.50"`,
-				"sec-ch-ua-full-version-list": `"Microsoft Edge";v="113.0.1774.50", "Chromium";v="113.0.5672.127", "Not-A.Brand";v="24.0.0.0"`,
-				"sec-ch-ua-mobile":            "?0",
-				"sec-ch-ua-model":             `""`,
-				"sec-ch-ua-platform":          `"Windows"`,
-				"sec-ch-ua-platform-version":  `"15.0.0"`,
-				"sec-fetch-dest":              "empty",
-				"sec-fetch-mode":              "cors",
-				"sec-fetch-site":              "same-origin",
-				"sec-ms-gec":                  util.GenerateSecMSGec(),
-				"sec-ms-gec-version":          "1-115.0.1866.1",
-				"x-ms-client-request-id":      uuidObj.String(),
-				"x-ms-useragent":              "azsdk-js-api-client-factory/1.0.0-beta.1 core-rest-pipeline/1.10.0 OS/Win32",
-				"user-agent":                  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.0.0 Safari/537.36 Edg/113.0.1774.50",
-				"Referer":                     "https://www.bing.com/search?q=Bing+AI&showconv=1",
-				"Referrer-Policy":             "origin-when-cross-origin",
-				"x-forwarded-for":             forwardedIP,
-				"Cookie":                      util.FormatCookieString(cookies),
-			}
-		},
-		cookies: cookies,
-		gptID:   gptID,
-		plugins: plugins,
+	locale := util.Ternary(options.Locale == "", "en-US", options.Locale)
+	location := resolveLocation(options.Location, locale)
+	slog.Info("Final conversation options", "options", optionsSet, "tone", tone,
+		"location", location.locationHint().Name, "region", location.Region)
+	return conversationOptions{
+		tone:       tone,
+		locale:     locale,
+		location:   location,
+		optionsSet: optionsSet,
+		gptID:      gptID,
+		plugins:    plugins,
+	}
+}
+
+// ChatOptions returns the default chat options, to be modified and passed as
+// AskStreamOptions.ChatOptions to override some of them for a request.
+func (o *Sydney) ChatOptions() ChatOptions {
+	options := o.chatOptions
+	options.Plugins = append([]string(nil), options.Plugins...)
+	return options
+}
+
+// requestConversationOptions returns the options of the request, computed from its chat options
+// if overridden.
+func (o *Sydney) requestConversationOptions(options AskStreamOptions) conversationOptions {
+	if options.ChatOptions == nil {
+		return o.conversationOptions
 	}
+	return computeConversationOptions(*options.ChatOptions)
+}
+
+func (o *Sydney) cookieString() string {
+	o.cookiesMu.Lock()
+	defer o.cookiesMu.Unlock()
+	return util.FormatCookieString(o.cookies)
+}
+
+// accountKey identifies the account of the cookies.
+func (o *Sydney) accountKey() string {
+	o.cookiesMu.Lock()
+	defer o.cookiesMu.Unlock()
+	if u := o.cookies["_U"]; u != "" {
+		return u
+	}
+	return util.FormatCookieString(o.cookies)
+}
+func (o *Sydney) copyCookies() map[string]string {
+	o.cookiesMu.Lock()
+	defer o.cookiesMu.Unlock()
+	return util.CopyMap(o.cookies)
 }
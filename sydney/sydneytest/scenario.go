@@ -0,0 +1,170 @@
+package sydneytest
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Frame is one ChatHub message sent by the fake server after receiving a chat request.
+type Frame struct {
+	Data  string        // JSON of the message, without the record separator
+	Delay time.Duration // how long to wait before sending the frame
+	// Drop closes the connection abruptly instead of sending Data. The frames after it are sent
+	// when the client connects to the conversation again, resuming the answer.
+	Drop bool
+}
+
+// Scenario is the scripted answer to one chat request.
+type Scenario struct {
+	Frames []Frame
+}
+
+// Source is a web search result returned by SearchScenario.
+type Source struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func mustMarshal(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func updateFrame(message map[string]any, cursor bool) Frame {
+	argument := map[string]any{
+		"messages":  []any{message},
+		"requestId": "fake-request",
+	}
+	if cursor {
+		argument["cursor"] = map[string]any{"j": "$['fake'].adaptiveCards[0].body[0].text", "p": -1}
+	}
+	return Frame{Data: mustMarshal(map[string]any{
+		"type":      1,
+		"target":    "update",
+		"arguments": []any{argument},
+	})}
+}
+
+func finalFrame(text string, suggestions []string) Frame {
+	suggestedResponses := make([]map[string]any, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		suggestedResponses = append(suggestedResponses, map[string]any{"text": suggestion})
+	}
+	return Frame{Data: mustMarshal(map[string]any{
+		"type":         2,
+		"invocationId": "0",
+		"item": map[string]any{
+			"messages": []any{
+				map[string]any{"author": "user", "text": "fake prompt"},
+				map[string]any{"author": "bot", "text": text, "suggestedResponses": suggestedResponses},
+			},
+			"result": map[string]any{"value": "Success", "message": text},
+		},
+	})}
+}
+
+// textFrames streams the chunks as growing texts of one bot message, like Bing does.
+func textFrames(chunks []string) ([]Frame, string) {
+	var frames []Frame
+	text := ""
+	for i, chunk := range chunks {
+		text += chunk
+		frames = append(frames, updateFrame(map[string]any{"author": "bot", "text": text}, i == 0))
+	}
+	return frames, text
+}
+
+// TextScenario streams the chunks as one answer and finishes with two suggested responses.
+func TextScenario(chunks ...string) Scenario {
+	frames, text := textFrames(chunks)
+	frames = append(frames, finalFrame(text, []string{"Tell me more.", "Thanks!"}))
+	return Scenario{Frames: frames}
+}
+
+// SearchScenario searches the web for the query before streaming the answer with citations.
+func SearchScenario(query string, sources []Source, chunks ...string) Scenario {
+	frames := []Frame{
+		updateFrame(map[string]any{
+			"author":      "bot",
+			"messageType": "InternalSearchQuery",
+			"text":        "Searching the web for: `" + query + "`",
+		}, false),
+		updateFrame(map[string]any{
+			"author":      "bot",
+			"messageType": "InternalSearchResult",
+			"hiddenText":  "```json\n{}\n```",
+			"text":        mustMarshal([]any{map[string]any{"web_search_results": sources}}),
+		}, false),
+	}
+	footer := ""
+	for i, source := range sources {
+		footer += "[" + strconv.Itoa(i+1) + "]: " + source.URL + " \"\"\n"
+	}
+	text := ""
+	for i, chunk := range chunks {
+		text += chunk
+		frames = append(frames, updateFrame(map[string]any{
+			"author": "bot",
+			"text":   text,
+			"adaptiveCards": []any{map[string]any{
+				"type": "AdaptiveCard",
+				"body": []any{map[string]any{"type": "TextBlock", "text": footer + "\n" + text}},
+			}},
+		}, i == 0))
+	}
+	frames = append(frames, finalFrame(text, nil))
+	return Scenario{Frames: frames}
+}
+
+// ApologyScenario streams the chunks and then revokes the answer. Without any chunk, it
+// simulates a prompt that triggers the Bing filter.
+func ApologyScenario(chunks ...string) Scenario {
+	frames, _ := textFrames(chunks)
+	frames = append(frames, updateFrame(map[string]any{
+		"author":        "bot",
+		"text":          "Sorry! That's on me, I can't give a response to that right now.",
+		"contentOrigin": "Apology",
+	}, len(chunks) == 0))
+	return Scenario{Frames: frames}
+}
+
+// DropScenario streams the chunks and then closes the connection abruptly before the answer
+// finishes, never to resume it.
+func DropScenario(chunks ...string) Scenario {
+	frames, _ := textFrames(chunks)
+	frames = append(frames, Frame{Drop: true})
+	return Scenario{Frames: frames}
+}
+
+// ResumeScenario streams the first dropAfter chunks and closes the connection abruptly. On the
+// next connection, it streams the answer again from the start and finishes it like TextScenario.
+func ResumeScenario(dropAfter int, chunks ...string) Scenario {
+	frames, _ := textFrames(chunks[:dropAfter])
+	frames = append(frames, Frame{Drop: true})
+	return Scenario{Frames: append(frames, TextScenario(chunks...).Frames...)}
+}
+
+// ErrorScenario finishes the answer with a failed result.
+func ErrorScenario(value string, message string) Scenario {
+	return Scenario{Frames: []Frame{{Data: mustMarshal(map[string]any{
+		"type":         2,
+		"invocationId": "0",
+		"item": map[string]any{
+			"result": map[string]any{"value": value, "message": message},
+		},
+	})}}}
+}
+
+// CaptchaScenario asks the user to solve a CAPTCHA.
+func CaptchaScenario() Scenario {
+	return ErrorScenario("CaptchaChallenge", "User needs to solve CAPTCHA to continue.")
+}
+
+// ThrottledScenario rejects the request because of too many requests.
+func ThrottledScenario() Scenario {
+	return ErrorScenario("Throttled", "Request is throttled.")
+}
@@ -0,0 +1,467 @@
+// Package sydneytest provides a fake Bing backend for testing the sydney package without
+// network access. It implements conversation creation, the ChatHub websocket, image and file
+// uploading, image creation, the user page and an OpenAI model list, and answers chat requests
+// with scripted scenarios.
+package sydneytest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const delimiter = "\x1e"
+
+// OpenAIKey is the key accepted by the OpenAI model list.
+const OpenAIKey = "fake-openai-key"
+
+type Server struct {
+	*httptest.Server
+
+	mu                       sync.Mutex
+	scenarios                []Scenario
+	chatRequests             []string
+	pings                    int
+	connections              int
+	conversations            int
+	createConversationStatus int
+	createConversationFails  int // the number of upcoming requests failing with the status
+	createConversationCalls  int
+	imagePollsBeforeReady    int
+	imagePolls               int
+	imageRejected            bool
+	chatHubStatus            int
+	uploadImageStatus        int
+	maxUserMessages          int
+	userMessages             map[string]int    // by conversation id
+	resumes                  map[string]resume // the rest of the dropped answers, by access token
+}
+
+// resume is the rest of an answer whose connection has been dropped, sent on the next one.
+type resume struct {
+	frames     []Frame
+	throttling map[string]any
+}
+
+// NewServer starts a fake Bing server. Chat requests are answered with a simple text scenario
+// unless other scenarios are enqueued.
+func NewServer() *Server {
+	o := &Server{maxUserMessages: 30, userMessages: map[string]int{}, resumes: map[string]resume{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/turing/conversation/create", o.handleCreateConversation)
+	mux.HandleFunc("/sydney/ChatHub", o.handleChatHub)
+	mux.HandleFunc("/images/kblob", o.handleUploadImage)
+	mux.HandleFunc("/sydney/UploadFile", o.handleUploadFile)
+	mux.HandleFunc("/images/create", o.handleCreateImage)
+	mux.HandleFunc("/images/create/async/results/", o.handleImageResults)
+	mux.HandleFunc("/search", o.handleSearch)
+	mux.HandleFunc("/v1/models", o.handleOpenAIModels)
+	o.Server = httptest.NewServer(mux)
+	return o
+}
+
+// WssDomain returns the value for sydney.Options.WssDomain.
+func (o *Server) WssDomain() string {
+	return "ws://" + strings.TrimPrefix(o.URL, "http://")
+}
+
+// CreateConversationURL returns the value for sydney.Options.CreateConversationURL.
+func (o *Server) CreateConversationURL() string {
+	return o.URL + "/turing/conversation/create"
+}
+
+// Enqueue adds scenarios to answer the upcoming chat requests in order.
+func (o *Server) Enqueue(scenarios ...Scenario) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.scenarios = append(o.scenarios, scenarios...)
+}
+
+// ChatRequests returns the JSON of all chat requests (type 4 messages) received.
+func (o *Server) ChatRequests() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.chatRequests...)
+}
+
+// Connections returns the number of ChatHub connections accepted.
+func (o *Server) Connections() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.connections
+}
+
+// Pings returns the number of pings (type 6 messages) received.
+func (o *Server) Pings() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.pings
+}
+
+// Conversations returns the number of conversations created.
+func (o *Server) Conversations() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conversations
+}
+
+// SetCreateConversationStatus makes conversation creation fail with the status code.
+// Zero restores the normal behavior.
+func (o *Server) SetCreateConversationStatus(code int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.createConversationStatus = code
+	o.createConversationFails = -1
+}
+
+// FailCreateConversation makes the next n requests of conversation creation fail with the
+// status code.
+func (o *Server) FailCreateConversation(code int, n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.createConversationStatus = code
+	o.createConversationFails = n
+}
+
+// CreateConversationCalls returns the number of requests of conversation creation received,
+// including the failed ones.
+func (o *Server) CreateConversationCalls() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.createConversationCalls
+}
+
+// SetMaxUserMessages sets the limit of user messages per conversation reported in the
+// throttling of final messages. The limit is not enforced.
+func (o *Server) SetMaxUserMessages(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.maxUserMessages = n
+}
+
+// SetImagePollsBeforeReady sets how many polls of the image creation result are answered
+// with an empty page before the images are ready.
+func (o *Server) SetImagePollsBeforeReady(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.imagePollsBeforeReady = n
+	o.imagePolls = 0
+}
+
+// SetImageRejected makes image creation reject the prompt.
+func (o *Server) SetImageRejected(rejected bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.imageRejected = rejected
+}
+
+// SetChatHubStatus makes ChatHub reject the connections with the status code. Zero restores
+// the normal behavior.
+func (o *Server) SetChatHubStatus(code int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.chatHubStatus = code
+}
+
+// SetUploadImageStatus makes image uploading fail with the status code. Zero restores the
+// normal behavior.
+func (o *Server) SetUploadImageStatus(code int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.uploadImageStatus = code
+}
+
+func (o *Server) nextScenario() Scenario {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.scenarios) == 0 {
+		return TextScenario("Hello", ", this is Bing.")
+	}
+	scenario := o.scenarios[0]
+	o.scenarios = o.scenarios[1:]
+	return scenario
+}
+
+func (o *Server) handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.createConversationCalls++
+	status := o.createConversationStatus
+	if status != 0 && o.createConversationFails > 0 {
+		o.createConversationFails--
+		if o.createConversationFails == 0 {
+			o.createConversationStatus = 0
+		}
+	}
+	if status == 0 {
+		o.conversations++
+	}
+	n := o.conversations
+	o.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Sydney-Encryptedconversationsignature", "fake-sec-access-token-"+strconv.Itoa(n))
+	w.Header().Set("X-Sydney-Conversationsignature", "fake-bearer-token-"+strconv.Itoa(n))
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"conversationId": "fake-conversation-" + strconv.Itoa(n),
+		"clientId":       "fake-client",
+		"result":         map[string]any{"value": "Success", "message": nil},
+	})
+}
+
+func (o *Server) handleChatHub(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	status := o.chatHubStatus
+	o.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	// The connection is closed before the context of its reads is canceled, since the websocket
+	// library records a read canceled during CloseNow racily.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer conn.CloseNow()
+	o.mu.Lock()
+	o.connections++
+	o.mu.Unlock()
+	// the handshake: {"protocol": "json", "version": 1}
+	_, v, err := conn.Read(ctx)
+	if err != nil || !strings.Contains(string(v), `"protocol"`) {
+		return
+	}
+	if err := conn.Write(ctx, websocket.MessageText, []byte("{}"+delimiter)); err != nil {
+		return
+	}
+	// a connection to the conversation of a dropped answer resumes it
+	token := r.URL.Query().Get("sec_access_token")
+	o.mu.Lock()
+	resumed, ok := o.resumes[token]
+	delete(o.resumes, token)
+	o.mu.Unlock()
+	if ok && !o.sendFrames(ctx, conn, token, resumed.frames, resumed.throttling) {
+		return
+	}
+	requests := make(chan string)
+	go func() {
+		defer close(requests)
+		for {
+			_, v, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			for _, item := range strings.Split(string(v), delimiter) {
+				if strings.Contains(item, `"type":6`) || strings.Contains(item, `"type": 6`) {
+					o.mu.Lock()
+					o.pings++
+					o.mu.Unlock()
+					continue
+				}
+				if !strings.Contains(item, `"type":4`) && !strings.Contains(item, `"type": 4`) {
+					continue
+				}
+				o.mu.Lock()
+				o.chatRequests = append(o.chatRequests, item)
+				o.mu.Unlock()
+				select {
+				case requests <- item:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	for request := range requests {
+		if !o.sendFrames(ctx, conn, token, o.nextScenario().Frames, o.countUserMessage(request)) {
+			return
+		}
+	}
+}
+
+// sendFrames sends the frames of an answer, and reports whether the connection is still open.
+// The frames after a dropping one are kept for the next connection with the access token.
+func (o *Server) sendFrames(ctx context.Context, conn *websocket.Conn, token string, frames []Frame,
+	throttling map[string]any) bool {
+	for i, frame := range frames {
+		if frame.Delay != 0 {
+			select {
+			case <-time.After(frame.Delay):
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if frame.Drop {
+			if i+1 < len(frames) {
+				o.mu.Lock()
+				o.resumes[token] = resume{frames: frames[i+1:], throttling: throttling}
+				o.mu.Unlock()
+			}
+			return false
+		}
+		if err := conn.Write(ctx, websocket.MessageText,
+			[]byte(withThrottling(frame.Data, throttling)+delimiter)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Server) countUserMessage(request string) map[string]any {
+	var v struct {
+		Arguments []struct {
+			ConversationID string `json:"conversationId"`
+		} `json:"arguments"`
+	}
+	_ = json.Unmarshal([]byte(request), &v)
+	conversationID := ""
+	if len(v.Arguments) != 0 {
+		conversationID = v.Arguments[0].ConversationID
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.userMessages[conversationID]++
+	return map[string]any{
+		"maxNumUserMessagesInConversation":               o.maxUserMessages,
+		"numUserMessagesInConversation":                  o.userMessages[conversationID],
+		"maxNumLongDocSummaryUserMessagesInConversation": 50,
+		"numLongDocSummaryUserMessagesInConversation":    0,
+	}
+}
+
+// withThrottling adds the throttling to the item of the final message of a successful answer.
+func withThrottling(data string, throttling map[string]any) string {
+	var frame map[string]any
+	if err := json.Unmarshal([]byte(data), &frame); err != nil || frame["type"] != float64(2) {
+		return data
+	}
+	item, ok := frame["item"].(map[string]any)
+	if !ok || item["messages"] == nil {
+		return data
+	}
+	item["throttling"] = throttling
+	return mustMarshal(frame)
+}
+
+func (o *Server) handleUploadImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	o.mu.Lock()
+	status := o.uploadImageStatus
+	o.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if err := r.ParseMultipartForm(16 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("knowledgeRequest") == "" || r.FormValue("imageBase64") == "" {
+		http.Error(w, "missing form fields", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"blobId":          "fake-blob",
+		"processedBlobId": "fake-blob",
+	})
+}
+
+func (o *Server) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseMultipartForm(16 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file.Close()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"fileName":      header.Filename,
+		"fileSize":      header.Size,
+		"fileType":      "text",
+		"isLongContext": false,
+		"docId":         "fake-doc",
+		"userId":        r.FormValue("userId"),
+		"result":        map[string]any{"value": "Success", "message": nil, "serviceVersion": "fake"},
+	})
+}
+
+func (o *Server) handleCreateImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<html><body><div id="giloader" data-c="/images/create/async/results/fake-result-id?q=%s&amp;IG=FAKE"></div></body></html>`,
+		r.URL.Query().Get("q"))
+}
+
+func (o *Server) handleImageResults(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.imagePolls++
+	ready := o.imagePolls > o.imagePollsBeforeReady
+	rejected := o.imageRejected
+	o.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html")
+	if rejected {
+		fmt.Fprint(w, `<div class="gil_err_mt">Please try again or come back later.</div>`)
+		return
+	}
+	if !ready {
+		return
+	}
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(w, `<img class="mimg" style="color: #fff" height="270" width="270" src="%s/th/id/fake-image-%d?w=270&amp;h=270" alt="fake" />`,
+			o.URL, i)
+	}
+}
+
+// handleSearch serves the page telling the user signed in with the _U cookie, if any.
+func (o *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if _, err := r.Cookie("_U"); err != nil {
+		fmt.Fprint(w, `<html><body><a id="id_s">Sign in</a></body></html>`)
+		return
+	}
+	fmt.Fprint(w, `<html><body><span id="id_n" data-clarity-mask="true" title="Fake User">Fake</span></body></html>`)
+}
+
+// handleOpenAIModels lists the models of the OpenAI API for OpenAIKey.
+func (o *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+OpenAIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{"message": "Incorrect API key provided", "type": "invalid_request_error"},
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   []map[string]any{{"id": "fake-gpt", "object": "model", "owned_by": "sydneytest"}},
+	})
+}
@@ -3,6 +3,7 @@ package sydney
 import (
 	"context"
 	"errors"
+	"sydneyqt/util"
 	"time"
 )
 
@@ -42,8 +43,9 @@ type CreateConversationResponse struct {
 	BearerToken           string                   `json:"bearerToken"`
 }
 type RawMessage struct {
-	Data  string
-	Error error
+	Data         string
+	Error        error
+	Reconnecting bool // the connection was lost and the answer is being resumed on a new one
 }
 
 const (
@@ -55,21 +57,38 @@ const (
 	MessageTypeExecutingTask      = "executing_task"
 	MessageTypeOpenAPICall        = "openapi_call"
 	MessageTypeGeneratedCode      = "generated_code"
+	MessageTypeCodeArtifact       = "code_artifact"
+	MessageTypeAdaptiveCard       = "adaptive_card" // Markdown of the adaptive cards besides the answer text
+	MessageTypeRaw                = "raw"           // the raw JSON of a ChatHub message the decoder doesn't handle
 	MessageTypeResolvingCaptcha   = "resolving_captcha"
+	MessageTypeReconnecting       = "reconnecting"
+	MessageTypeThrottling         = "throttling"
 	MessageTypeMessageText        = "message"
 	MessageTypeSuggestedResponses = "suggested_responses"
 	MessageTypeError              = "error"
 )
 
 var (
-	ErrMessageRevoke   = errors.New("message revoke detected")
-	ErrMessageFiltered = errors.New("message triggered the Bing filter")
+	ErrMessageRevoke    = errors.New("message revoke detected")
+	ErrMessageFiltered  = errors.New("message triggered the Bing filter")
+	ErrTurnLimitReached = errors.New("the conversation has reached its limit of user messages; " +
+		"please start a new one")
+	ErrSessionBusy = errors.New("the session is still answering the previous question")
 )
 
 type Message struct {
 	Type  string
 	Text  string
 	Error error
+
+	// Structured payloads of specific message types. Text still holds their JSON encoding.
+	Sources     []SourceAttribute // MessageTypeSearchResult
+	Suggestions []string          // MessageTypeSuggestedResponses
+	Image       *GenerativeImage  // MessageTypeGenerativeImage
+	Music       *GenerativeMusic  // MessageTypeGenerativeMusic
+	Throttling  *Throttling       // MessageTypeThrottling
+	// MessageTypeCodeArtifact, sent when a code interpreter run is over
+	CodeArtifact *CodeArtifact
 }
 type ChatMessage struct {
 	Arguments    []Argument `json:"arguments"`
@@ -125,6 +144,7 @@ type Participant struct {
 }
 type PreviousMessage struct {
 	Author      string `json:"author"`
+	Text        string `json:"text,omitempty"`
 	Description string `json:"description"`
 	ContextType string `json:"contextType"`
 	MessageType string `json:"messageType"`
@@ -138,20 +158,124 @@ type Options struct {
 	Locale                string
 	WssDomain             string
 	CreateConversationURL string
+	Endpoints             Endpoints
 	NoSearch              bool
 	UseClassic            bool
 	GPT4Turbo             bool
 	BypassServer          string
-	Plugins               []string
+	// CaptchaSolver solves the CAPTCHA Bing asks for. If nil, BypassServerCaptchaSolver is used
+	// if BypassServer is set, or else BrowserCaptchaSolver.
+	CaptchaSolver CaptchaSolver
+	Plugins       []string
+	Location      Location
+	// ClientProfile is the name of the client profile, either one of ClientProfiles or of
+	// CustomClientProfiles. ClientProfileEdgeDesktop if empty.
+	ClientProfile        string
+	CustomClientProfiles []ClientProfile
+	// ReconnectAttempts is how many times to reconnect and resume the answer when the ChatHub
+	// connection drops. The question is not asked again, and the answer fails with
+	// ErrAnswerInterrupted if it is not resumed. Zero means the default (2), and a negative
+	// value disables reconnecting.
+	ReconnectAttempts int
+	// OnCookiesUpdated is called with all cookies after Bing refreshes some of them. If nil,
+	// the cookies are saved to cookies.json.
+	OnCookiesUpdated func(cookies map[string]string)
+	// Timeouts of the ChatHub connection. Zero fields take the values of DefaultTimeouts.
+	Timeouts Timeouts
+	// RetryPolicy of the requests made before the answer starts streaming. Zero fields take
+	// the values of DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// ConversationPool provides conversations created in advance if not nil. It can be shared
+	// by many Sydney instances.
+	ConversationPool *ConversationPool
+}
+
+// Timeouts of the ChatHub connection. A negative timeout disables it. Operations that time
+// out fail with TimeoutError.
+type Timeouts struct {
+	Dial     time.Duration // connecting to ChatHub, including the handshake
+	ReadIdle time.Duration // waiting for the next message, e.g. while Bing runs some code
+	Write    time.Duration // sending a message
+	Total    time.Duration // the whole answer, including reconnecting
+	// Keepalive is the interval of pinging ChatHub while waiting for the answer.
+	Keepalive time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Dial:      10 * time.Second,
+	ReadIdle:  30 * time.Second,
+	Write:     5 * time.Second,
+	Total:     -1,
+	Keepalive: 5 * time.Second,
+}
+
+// withDefaults replaces the zero timeouts with the default ones.
+func (o Timeouts) withDefaults() Timeouts {
+	orDefault := func(value time.Duration, defaultValue time.Duration) time.Duration {
+		return util.Ternary(value == 0, defaultValue, value)
+	}
+	return Timeouts{
+		Dial:      orDefault(o.Dial, DefaultTimeouts.Dial),
+		ReadIdle:  orDefault(o.ReadIdle, DefaultTimeouts.ReadIdle),
+		Write:     orDefault(o.Write, DefaultTimeouts.Write),
+		Total:     orDefault(o.Total, DefaultTimeouts.Total),
+		Keepalive: orDefault(o.Keepalive, DefaultTimeouts.Keepalive),
+	}
+}
+
+// ChatOptions are the options of Options which can be overridden per request.
+type ChatOptions struct {
+	ConversationStyle string
+	Locale            string
+	NoSearch          bool
+	UseClassic        bool
+	GPT4Turbo         bool
+	Plugins           []string
+	Location          Location
+}
+
+func (o Options) chatOptions() ChatOptions {
+	return ChatOptions{
+		ConversationStyle: o.ConversationStyle,
+		Locale:            o.Locale,
+		NoSearch:          o.NoSearch,
+		UseClassic:        o.UseClassic,
+		GPT4Turbo:         o.GPT4Turbo,
+		Plugins:           o.Plugins,
+		Location:          o.Location,
+	}
+}
+
+// conversationOptions are what is sent to Bing for the chat options.
+type conversationOptions struct {
+	tone       string
+	locale     string
+	location   Location // resolved with the locale
+	optionsSet []string
+	gptID      string
+	plugins    []ArgumentPlugin
 }
 type AskStreamOptions struct {
 	StopCtx        context.Context
 	Prompt         string
 	WebpageContext string
+	// History is the chat history sent as native previous messages. If empty, WebpageContext is
+	// sent as one web page instead.
+	History        []util.ChatMessage
 	ImageURL       string
 	UploadFilePath string
+	// ChatOptions overrides the chat options of the Sydney for this request if not nil. Start
+	// from Sydney.ChatOptions to override only some of them.
+	ChatOptions *ChatOptions
+	// EmitRaw also sends the ChatHub messages which are not handled as MessageTypeRaw, so that
+	// new Bing features can be used before they are supported.
+	EmitRaw bool
+	// CitationStyle rewrites the citation markers of the answer text, such as [^1^], with the
+	// cited sources, e.g. CitationStyleFootnotes. The markers are kept as is by default.
+	CitationStyle string
 
-	messageID            string // A random uuid. Optional.
+	messageID            string       // A random uuid. Optional.
+	turn                 *sessionTurn // Continue the conversation of the session instead of creating a new one. Optional.
 	disableCaptchaBypass bool
 }
 type UploadImagePayload struct {
@@ -179,6 +303,24 @@ type GenerativeImage struct {
 	Text string `json:"text"`
 	URL  string `json:"url"`
 }
+
+// Throttling is the usage of the conversation reported by Bing at the end of each answer.
+type Throttling struct {
+	MaxNumUserMessagesInConversation               int `json:"maxNumUserMessagesInConversation"`
+	NumUserMessagesInConversation                  int `json:"numUserMessagesInConversation"`
+	MaxNumLongDocSummaryUserMessagesInConversation int `json:"maxNumLongDocSummaryUserMessagesInConversation"`
+	NumLongDocSummaryUserMessagesInConversation    int `json:"numLongDocSummaryUserMessagesInConversation"`
+}
+
+// RemainingUserMessages returns how many more user messages the conversation accepts,
+// or -1 if Bing reports no limit.
+func (o Throttling) RemainingUserMessages() int {
+	if o.MaxNumUserMessagesInConversation == 0 {
+		return -1
+	}
+	return max(o.MaxNumUserMessagesInConversation-o.NumUserMessagesInConversation, 0)
+}
+
 type GenerativeMusic struct {
 	IFrameID  string `json:"iframeid"`
 	RequestID string `json:"requestid"`
@@ -2,6 +2,7 @@ package sydney
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -12,18 +13,15 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"sydneyqt/util"
 	"time"
 )
 
-func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
-	_, client, err := util.MakeHTTPClient(o.proxy, 60*time.Second)
+func (o *Sydney) UploadImage(ctx context.Context, jpgImgData []byte) (string, error) {
+	_, client, err := o.makeHTTPClient(60 * time.Second)
 	if err != nil {
 		return "", err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&FORM=hpcodx")
 	imageBase64 := base64.StdEncoding.EncodeToString(jpgImgData)
 	uploadImagePayload := UploadImagePayload{
 		ImageInfo: map[string]any{},
@@ -35,7 +33,7 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 			},
 			ConvoData: ConvoData{
 				Convoid:   "",
-				Convotone: o.conversationStyle,
+				Convotone: o.conversationOptions.tone,
 			},
 		},
 	}
@@ -43,10 +41,13 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("cannot marshal uploadImagePayload: %w", err)
 	}
-	resp, err := client.R().EnableForceMultipart().SetFormData(map[string]string{
-		"knowledgeRequest": string(payload),
-		"imageBase64":      imageBase64,
-	}).Post("https://www.bing.com/images/kblob")
+	resp, err := o.retryRequest(ctx, "upload image", func() (*req.Response, error) {
+		return client.R().SetContext(ctx).SetHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&FORM=hpcodx").
+			EnableForceMultipart().SetFormData(map[string]string{
+			"knowledgeRequest": string(payload),
+			"imageBase64":      imageBase64,
+		}).Post(o.endpoints.ImageUpload)
+	})
 	if err != nil {
 		return "", fmt.Errorf("cannot fire upload request: %w", err)
 	}
@@ -58,12 +59,13 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 	if result.BlobId == "" {
 		return "", errors.New("blobId is empty")
 	}
-	return "https://www.bing.com/images/blob?bcid=" + result.BlobId, nil
+	return o.endpoints.ImageBlob + "?bcid=" + result.BlobId, nil
 }
 
-func (o *Sydney) uploadFile(uploadFilePath string, conversation CreateConversationResponse) (UploadFileResult, error) {
+func (o *Sydney) uploadFile(ctx context.Context, uploadFilePath string, conversation CreateConversationResponse,
+	tone string) (UploadFileResult, error) {
 	var empty UploadFileResult
-	_, client, err := util.MakeHTTPClient(o.proxy, 60*time.Second)
+	_, client, err := o.makeHTTPClient(60 * time.Second)
 	if err != nil {
 		return empty, err
 	}
@@ -79,29 +81,31 @@ func (o *Sydney) uploadFile(uploadFilePath string, conversation CreateConversati
 	//	return empty, errors.New("file to upload must be less than 1MB")
 	//}
 	var response UploadFileResponse
-	resp, err := client.R().
-		SetHeader("Authorization", "Bearer "+conversation.BearerToken).
-		SetHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1").
-		SetHeader("Origin", "https://www.bing.com").
-		SetFileUpload(req.FileUpload{
-			ParamName: "file",
-			FileName:  filepath.Base(uploadFilePath),
-			GetFileContent: func() (io.ReadCloser, error) {
-				return io.NopCloser(bytes.NewReader(f)), nil
-			},
-			FileSize:    int64(len(f)),
-			ContentType: "application/octet-stream",
-		}).SetFormData(map[string]string{
-		"conversationId":              conversation.ConversationId,
-		"tone":                        o.conversationStyle,
-		"userId":                      conversation.ClientId,
-		"enableFileUploadLongContext": "true",
-	}).SetSuccessResult(&response).Post("https://sydney.bing.com/sydney/UploadFile")
+	resp, err := o.retryRequest(ctx, "upload file", func() (*req.Response, error) {
+		return client.R().SetContext(ctx).
+			SetHeader("Authorization", "Bearer "+conversation.BearerToken).
+			SetHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1").
+			SetHeader("Origin", "https://www.bing.com").
+			SetFileUpload(req.FileUpload{
+				ParamName: "file",
+				FileName:  filepath.Base(uploadFilePath),
+				GetFileContent: func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(f)), nil
+				},
+				FileSize:    int64(len(f)),
+				ContentType: "application/octet-stream",
+			}).SetFormData(map[string]string{
+			"conversationId":              conversation.ConversationId,
+			"tone":                        tone,
+			"userId":                      conversation.ClientId,
+			"enableFileUploadLongContext": "true",
+		}).SetSuccessResult(&response).Post(o.endpoints.FileUpload)
+	})
 	if err != nil {
 		return empty, err
 	}
 	if resp.IsErrorState() {
-		return empty, errors.New("error status code: " + strconv.Itoa(resp.GetStatusCode()))
+		return empty, newStatusError("upload file", resp.GetStatusCode())
 	}
 	if response.Result.Value != "Success" {
 		return empty, errors.New("upload returned failed result: " + response.Result.Message)
@@ -0,0 +1,74 @@
+package sydney
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sydneyqt/sydney/sydneytest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadImage(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	url, err := newFakeSydney(server).UploadImage(context.Background(), []byte("fake jpeg"))
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL+"/images/blob?bcid=fake-blob", url)
+}
+
+func TestUploadFile(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	syd := newFakeSydney(server)
+	conversation, err := syd.createConversation(context.Background())
+	assert.Nil(t, err)
+	t.Run("allowed file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		assert.Nil(t, os.WriteFile(path, []byte("some notes"), 0644))
+		result, err := syd.uploadFile(context.Background(), path, conversation, "Creative")
+		assert.Nil(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, "notes.txt", result.Response.FileName)
+		assert.Equal(t, "text", result.RealFileType)
+		assert.Contains(t, result.FileHiddenText, "fake-doc")
+	})
+	t.Run("disallowed file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "program.exe")
+		assert.Nil(t, os.WriteFile(path, []byte("MZ"), 0644))
+		_, err := syd.uploadFile(context.Background(), path, conversation, "Creative")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGenerateImage(t *testing.T) {
+	server := sydneytest.NewServer()
+	defer server.Close()
+	syd := newFakeSydney(server)
+	image := GenerativeImage{
+		Text: "a pigeon",
+		URL:  syd.endpoints.ImageCreate + "?q=a+pigeon&iframeid=fake",
+	}
+	t.Run("created", func(t *testing.T) {
+		result, err := syd.GenerateImage(context.Background(), image)
+		assert.Nil(t, err)
+		assert.Len(t, result.ImageURLs, 4)
+		assert.Equal(t, "a pigeon", result.Text)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		server.SetImageRejected(true)
+		defer server.SetImageRejected(false)
+		_, err := syd.GenerateImage(context.Background(), image)
+		assert.NotNil(t, err)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		_, err := syd.GenerateImage(ctx, image)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
@@ -1,36 +1,56 @@
 package sydney
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"nhooyr.io/websocket"
 	"strings"
-	"sydneyqt/util"
 	"time"
 )
 
 type Conn struct {
-	debug bool
+	debug    bool
+	timeouts Timeouts
 	*websocket.Conn
+	stopKeepalive context.CancelFunc
 }
 
-func (o *Conn) WriteWithTimeout(v []byte) error {
-	ctx, cancel := util.CreateTimeoutContext(5 * time.Second)
+// withTimeout returns a context of the parent which expires after the timeout, or which
+// never expires by itself if the timeout is disabled.
+func withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// timeoutError returns a TimeoutError if the context of the operation has expired while the
+// parent has not, and the error as is otherwise.
+func timeoutError(err error, ctx context.Context, parent context.Context, op string, timeout time.Duration) error {
+	if err == nil || parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return &TimeoutError{Op: op, Timeout: timeout, Err: err}
+}
+
+func (o *Conn) WriteWithTimeout(parent context.Context, v []byte) error {
+	ctx, cancel := withTimeout(parent, o.timeouts.Write)
 	defer cancel()
 	bytes := append(v, []byte(string(delimiter))...)
 	slog.Debug("WriteWithTimeout", "v", string(bytes))
-	return o.Write(ctx, websocket.MessageText, bytes)
+	return timeoutError(o.Write(ctx, websocket.MessageText, bytes), ctx, parent, TimeoutWrite, o.timeouts.Write)
 }
-func (o *Conn) ReadWithTimeout() ([]string, error) {
-	ctx, cancel := util.CreateTimeoutContext(30 * time.Second)
+func (o *Conn) ReadWithTimeout(parent context.Context) ([]string, error) {
+	ctx, cancel := withTimeout(parent, o.timeouts.ReadIdle)
 	defer cancel()
 	typ, v, err := o.Read(ctx)
 	if err != nil {
 		var closeErr websocket.CloseError
 		if errors.As(err, &closeErr) && closeErr.Code == websocket.StatusNormalClosure {
-			err = errors.Join(err, errors.New("please check if the chat context is too long"))
+			err = errors.Join(err, ErrContextTooLong)
 		}
-		return nil, err
+		return nil, timeoutError(err, ctx, parent, TimeoutReadIdle, o.timeouts.ReadIdle)
 	}
 	if typ != websocket.MessageText {
 		return nil, nil
@@ -45,3 +65,38 @@ func (o *Conn) ReadWithTimeout() ([]string, error) {
 	}
 	return arr, nil
 }
+
+// keepalive pings the server every interval until the connection is closed, independently
+// of reading, so that the connection stays alive while Bing is working on a long answer.
+func (o *Conn) keepalive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	var ctx context.Context
+	ctx, o.stopKeepalive = context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := o.WriteWithTimeout(ctx, []byte(`{"type": 6}`)); err != nil {
+					if ctx.Err() == nil {
+						slog.Warn("Cannot ping ChatHub", "err", err)
+					}
+					return
+				}
+			}
+		}
+	}()
+}
+
+// CloseNow stops the keepalive and closes the connection.
+func (o *Conn) CloseNow() error {
+	if o.stopKeepalive != nil {
+		o.stopKeepalive()
+	}
+	return o.Conn.CloseNow()
+}
@@ -0,0 +1,249 @@
+package util
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/samber/lo"
+
+	getproxy "github.com/rapid7/go-get-proxied/proxy"
+)
+
+// HTTPClientOptions decides which clients HTTPClientFactory.Clients returns.
+type HTTPClientOptions struct {
+	Proxy             string // a proxy URL or a ProxyConfig in JSON; the system proxy if empty
+	Timeout           time.Duration
+	ImpersonateChrome bool              // impersonate the TLS fingerprint and headers of Chrome
+	Headers           map[string]string // common headers of the req client
+}
+
+func (o HTTPClientOptions) key() string {
+	headers := lo.MapToSlice(o.Headers, func(k string, v string) string {
+		return strings.ToLower(k) + ": " + v
+	})
+	slices.Sort(headers)
+	return strings.Join(append([]string{o.Proxy, o.Timeout.String(),
+		Ternary(o.ImpersonateChrome, "chrome", "")}, headers...), "\n")
+}
+
+type httpClients struct {
+	proxy     string
+	client    *http.Client
+	reqClient *req.Client
+}
+
+// HTTPClientFactory hands out HTTP clients which are built once for each HTTPClientOptions
+// and then shared, so that their connections are reused, or clones of them for the callers
+// which configure them. The system proxy is detected once, and again on RefreshSystemProxy.
+type HTTPClientFactory struct {
+	mu      sync.Mutex
+	clients map[string]httpClients
+	routers map[string]*proxyRouter // by proxy config
+
+	systemProxyMu sync.Mutex
+	systemProxy   *url.URL
+	detected      bool
+}
+
+var DefaultHTTPClientFactory = &HTTPClientFactory{}
+
+// Clients returns the clients of the options. They are shared, so set headers and cookies on
+// the requests instead of the clients; the req client has no cookie jar for the same reason.
+func (o *HTTPClientFactory) Clients(options HTTPClientOptions) (*http.Client, *req.Client, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := options.key()
+	if clients, ok := o.clients[key]; ok {
+		return clients.client, clients.reqClient, nil
+	}
+	client, reqClient, err := o.newClients(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	reqClient.SetCookieJar(nil) // shared by accounts, which send their own cookies
+	if o.clients == nil {
+		o.clients = map[string]httpClients{}
+	}
+	o.clients[key] = httpClients{proxy: options.Proxy, client: client, reqClient: reqClient}
+	return client, reqClient, nil
+}
+
+// NewClients returns clones of the shared clients of the options, which are the caller's own to
+// configure, and whose req client has a cookie jar. The http client shares the transport, and
+// thus the connections, of the shared one, so its transport must not be changed. The req
+// client has a copy of the transport instead, which also keeps its common headers.
+func (o *HTTPClientFactory) NewClients(options HTTPClientOptions) (*http.Client, *req.Client, error) {
+	client, reqClient, err := o.Clients(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientClone := *client
+	reqClientClone := reqClient.Clone()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	reqClientClone.SetCookieJar(jar)
+	return &clientClone, reqClientClone, nil
+}
+
+// newClients builds the clients of the options. The caller must hold mu.
+func (o *HTTPClientFactory) newClients(options HTTPClientOptions) (*http.Client, *req.Client, error) {
+	router, err := o.router(options.Proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = router.proxy
+	reqClient := req.C()
+	if options.ImpersonateChrome {
+		reqClient.ImpersonateChrome()
+	}
+	reqClient.SetProxy(router.proxy).
+		SetCommonHeaders(options.Headers)
+	client := &http.Client{Transport: transport}
+	if options.Timeout != 0 {
+		client.Timeout = options.Timeout
+		reqClient.SetTimeout(options.Timeout)
+	}
+	return client, reqClient, nil
+}
+
+// router returns the router of the proxy config, which is shared by the clients so that the
+// proxies are rotated and health checked once. The caller must hold mu.
+func (o *HTTPClientFactory) router(proxy string) (*proxyRouter, error) {
+	if router, ok := o.routers[proxy]; ok {
+		return router, nil
+	}
+	config, err := ParseProxyConfig(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if o.routers == nil {
+		o.routers = map[string]*proxyRouter{}
+	}
+	o.routers[proxy] = newProxyRouter(config, o.currentSystemProxy)
+	return o.routers[proxy], nil
+}
+
+// Release stops the health check of the proxy config, which is no longer used, and drops its
+// shared clients. The clients already handed out keep working.
+func (o *HTTPClientFactory) Release(proxy string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if router, ok := o.routers[proxy]; ok {
+		router.close()
+		delete(o.routers, proxy)
+	}
+	for key, clients := range o.clients {
+		if clients.proxy == proxy {
+			clients.client.CloseIdleConnections()
+			clients.reqClient.GetClient().CloseIdleConnections()
+			delete(o.clients, key)
+		}
+	}
+}
+
+// Close releases all proxy configs, stopping the health checks.
+func (o *HTTPClientFactory) Close() {
+	o.mu.Lock()
+	proxies := lo.Keys(o.routers)
+	o.mu.Unlock()
+	for _, proxy := range proxies {
+		o.Release(proxy)
+	}
+}
+
+// ProxyURL returns the URL of the proxy which the requests to rawURL go through by the proxy
+// config, or "" if they connect directly. It is for the programs which only take a proxy URL.
+func (o *HTTPClientFactory) ProxyURL(proxy string, rawURL string) (string, error) {
+	o.mu.Lock()
+	router, err := o.router(proxy)
+	o.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	u, err := router.proxy(req)
+	return urlString(u), err
+}
+
+// currentSystemProxy returns the system proxy, detecting it on the first call.
+func (o *HTTPClientFactory) currentSystemProxy() *url.URL {
+	o.systemProxyMu.Lock()
+	defer o.systemProxyMu.Unlock()
+	if !o.detected {
+		o.systemProxy = detectSystemProxy()
+		o.detected = true
+	}
+	return o.systemProxy
+}
+
+// RefreshSystemProxy detects the system proxy again. The idle connections are closed if it
+// has changed, so that the next requests go through the new one.
+func (o *HTTPClientFactory) RefreshSystemProxy() {
+	systemProxy := detectSystemProxy()
+	o.systemProxyMu.Lock()
+	changed := !o.detected || urlString(systemProxy) != urlString(o.systemProxy)
+	o.systemProxy = systemProxy
+	o.detected = true
+	o.systemProxyMu.Unlock()
+	if !changed {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, clients := range o.clients {
+		clients.client.CloseIdleConnections()
+		clients.reqClient.GetClient().CloseIdleConnections()
+	}
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// detectSystemProxy reads the system proxy. The provider logs to the standard logger, which is
+// left alone as it is shared by the other goroutines; it only runs on detecting anyway.
+func detectSystemProxy() *url.URL {
+	provider := getproxy.NewProvider("")
+	for _, proxy := range []getproxy.Proxy{
+		provider.GetHTTPProxy("https://www.bing.com"),
+		provider.GetHTTPSProxy("https://www.bing.com"),
+		provider.GetSOCKSProxy("https://www.bing.com"),
+	} {
+		if proxy != nil {
+			return proxy.URL()
+		}
+	}
+	return nil
+}
+
+// MakeHTTPClient returns the shared clients of DefaultHTTPClientFactory impersonating Chrome.
+// Use DefaultHTTPClientFactory.NewClients for clients to configure.
+func MakeHTTPClient(proxy string, timeout time.Duration) (*http.Client, *req.Client, error) {
+	return MakeHTTPClientImpersonating(proxy, timeout, true)
+}
+
+// MakeHTTPClientImpersonating is MakeHTTPClient whose req client impersonates the TLS
+// fingerprint and headers of Chrome only if impersonateChrome is true.
+func MakeHTTPClientImpersonating(proxy string, timeout time.Duration,
+	impersonateChrome bool) (*http.Client, *req.Client, error) {
+	return DefaultHTTPClientFactory.Clients(HTTPClientOptions{
+		Proxy:             proxy,
+		Timeout:           timeout,
+		ImpersonateChrome: impersonateChrome,
+	})
+}
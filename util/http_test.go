@@ -0,0 +1,81 @@
+package util
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientFactory(t *testing.T) {
+	factory := &HTTPClientFactory{}
+	client, reqClient, err := factory.Clients(HTTPClientOptions{Timeout: 15 * time.Second, ImpersonateChrome: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 15*time.Second, client.Timeout)
+	assert.Nil(t, reqClient.GetClient().Jar)
+
+	t.Run("shared", func(t *testing.T) {
+		client2, reqClient2, err := factory.Clients(HTTPClientOptions{Timeout: 15 * time.Second, ImpersonateChrome: true})
+		assert.Nil(t, err)
+		assert.Same(t, client, client2)
+		assert.Same(t, reqClient, reqClient2)
+		client3, _, err := factory.Clients(HTTPClientOptions{Timeout: 30 * time.Second, ImpersonateChrome: true})
+		assert.Nil(t, err)
+		assert.NotSame(t, client, client3)
+	})
+	t.Run("new", func(t *testing.T) {
+		client2, reqClient2, err := factory.NewClients(HTTPClientOptions{Timeout: 15 * time.Second, ImpersonateChrome: true})
+		assert.Nil(t, err)
+		assert.NotSame(t, client, client2)
+		assert.NotSame(t, reqClient, reqClient2)
+		assert.Same(t, client.Transport, client2.Transport)
+		assert.NotNil(t, reqClient2.GetClient().Jar)
+		assert.Nil(t, reqClient.GetClient().Jar)
+		reqClient2.SetCommonHeader("Referer", "https://www.youtube.com/")
+		assert.Empty(t, reqClient.Headers.Get("Referer"))
+	})
+	t.Run("headers", func(t *testing.T) {
+		options := HTTPClientOptions{Headers: map[string]string{"User-Agent": "a", "Sec-Ch-Ua": "b"}}
+		_, reqClient, err := factory.Clients(options)
+		assert.Nil(t, err)
+		assert.Equal(t, "a", reqClient.Headers.Get("User-Agent"))
+		_, reqClient2, err := factory.Clients(HTTPClientOptions{Headers: map[string]string{"sec-ch-ua": "b", "user-agent": "a"}})
+		assert.Nil(t, err)
+		assert.Same(t, reqClient, reqClient2)
+		_, reqClient3, err := factory.Clients(HTTPClientOptions{Headers: map[string]string{"User-Agent": "c"}})
+		assert.Nil(t, err)
+		assert.NotSame(t, reqClient, reqClient3)
+	})
+	t.Run("proxy", func(t *testing.T) {
+		client, _, err := factory.Clients(HTTPClientOptions{Proxy: "http://127.0.0.1:7890"})
+		assert.Nil(t, err)
+		proxyURL, err := client.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Host: "www.bing.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, "http://127.0.0.1:7890", proxyURL.String())
+		_, _, err = factory.Clients(HTTPClientOptions{Proxy: "http://[::1"})
+		assert.NotNil(t, err)
+	})
+	t.Run("refresh system proxy", func(t *testing.T) {
+		systemProxy, _ := url.Parse("http://127.0.0.1:7890")
+		factory.systemProxy, factory.detected = systemProxy, true
+		proxyURL, err := client.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Host: "www.bing.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, systemProxy, proxyURL)
+		factory.RefreshSystemProxy() // the system proxy is read on every request
+		client2, _, err := factory.Clients(HTTPClientOptions{Timeout: 15 * time.Second, ImpersonateChrome: true})
+		assert.Nil(t, err)
+		assert.Same(t, client, client2)
+		proxyURL, err = client.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Host: "www.bing.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, urlString(factory.currentSystemProxy()), urlString(proxyURL))
+	})
+}
+
+func BenchmarkHTTPClientFactory(b *testing.B) {
+	factory := &HTTPClientFactory{}
+	for i := 0; i < b.N; i++ {
+		_, _, _ = factory.Clients(HTTPClientOptions{Timeout: 15 * time.Second, ImpersonateChrome: true})
+	}
+}
@@ -7,18 +7,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/imroc/req/v3"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
 	_ "image/jpeg"
 	_ "image/png"
-	"io"
-	"log"
 	"log/slog"
 	"math/rand"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,7 +25,6 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/ncruces/zenity"
-	getproxy "github.com/rapid7/go-get-proxied/proxy"
 )
 
 func RandIntInclusive(min int, max int) int {
@@ -43,45 +37,6 @@ func Ternary[T any](expression bool, trueResult T, falseResult T) T {
 		return falseResult
 	}
 }
-func MakeHTTPClient(proxy string, timeout time.Duration) (*http.Client, *req.Client, error) {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	reqClient := req.C().SetProxyURL(proxy).ImpersonateChrome()
-	if proxy != "" { // user filled proxy
-		proxyURL, err := url.Parse(proxy)
-		if err != nil {
-			return nil, nil, err
-		}
-		transport.Proxy = http.ProxyURL(proxyURL)
-		reqClient.SetProxyURL(proxy)
-	} else { // try to get system proxy
-		log.SetOutput(io.Discard) // FIXME this is a dirty fix that may result in concurrency problems
-		proxies := []getproxy.Proxy{
-			getproxy.NewProvider("").GetHTTPProxy("https://www.bing.com"),
-			getproxy.NewProvider("").GetHTTPSProxy("https://www.bing.com"),
-			getproxy.NewProvider("").GetSOCKSProxy("https://www.bing.com"),
-		}
-		log.SetOutput(os.Stdout)
-		var sysProxy getproxy.Proxy
-		for _, p := range proxies {
-			p := p
-			if p != nil {
-				sysProxy = p
-				break
-			}
-		}
-		if sysProxy != nil { // valid system proxy
-			transport.Proxy = http.ProxyURL(sysProxy.URL())
-			reqClient.SetProxyURL(sysProxy.URL().String())
-		}
-	}
-	client := &http.Client{}
-	client.Transport = transport
-	if timeout != time.Duration(0) {
-		client.Timeout = timeout
-		reqClient.SetTimeout(timeout)
-	}
-	return client, reqClient, nil
-}
 func FormatCookieString(cookies map[string]string) string {
 	str := ""
 	for k, v := range cookies {
@@ -130,7 +85,10 @@ type FileCookie struct {
 }
 
 func ReadCookiesFileRaw() ([]FileCookie, error) {
-	v, err := os.ReadFile(WithPath("cookies.json"))
+	return ReadCookiesFileRawAt(WithPath("cookies.json"))
+}
+func ReadCookiesFileRawAt(path string) ([]FileCookie, error) {
+	v, err := os.ReadFile(path)
 	if err != nil {
 		return nil, nil
 	}
@@ -142,8 +100,11 @@ func ReadCookiesFileRaw() ([]FileCookie, error) {
 	return cookies, nil
 }
 func ReadCookiesFile() (map[string]string, error) {
+	return ReadCookiesFileAt(WithPath("cookies.json"))
+}
+func ReadCookiesFileAt(path string) (map[string]string, error) {
 	res := map[string]string{}
-	cookies, err := ReadCookiesFileRaw()
+	cookies, err := ReadCookiesFileRawAt(path)
 	if err != nil {
 		return nil, err
 	}
@@ -153,6 +114,9 @@ func ReadCookiesFile() (map[string]string, error) {
 	return res, nil
 }
 func UpdateCookiesFile(cookies map[string]string) error {
+	return UpdateCookiesFileAt(WithPath("cookies.json"), cookies)
+}
+func UpdateCookiesFileAt(path string, cookies map[string]string) error {
 	var arr []FileCookie
 	for k, v := range cookies {
 		arr = append(arr, FileCookie{
@@ -164,7 +128,7 @@ func UpdateCookiesFile(cookies map[string]string) error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(WithPath("cookies.json"), v, 0644)
+	err = os.WriteFile(path, v, 0644)
 	if err != nil {
 		return err
 	}
@@ -1,6 +1,9 @@
 package main
 
-import "sydneyqt/sydney"
+import (
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+)
 
 type CreateConversationRequest struct {
 	Cookies string `json:"cookies"`
@@ -11,16 +14,25 @@ type CreateImageRequest struct {
 	Cookies string                 `json:"cookies"`
 }
 
+type DiagnosticsRequest struct {
+	Cookies string `json:"cookies"`
+}
+
 type ChatStreamRequest struct {
-	Prompt            string   `json:"prompt"`
-	WebpageContext    string   `json:"context"`
-	Cookies           string   `json:"cookies"`
-	ImageURL          string   `json:"imageUrl"`
-	NoSearch          bool     `json:"noSearch"`
-	UseGPT4Turbo      bool     `json:"gpt4turbo"`
-	UseClassic        bool     `json:"classic"`
-	ConversationStyle string   `json:"conversationStyle"`
-	Plugins           []string `json:"plugins"`
+	Prompt            string             `json:"prompt"`
+	WebpageContext    string             `json:"context"`
+	History           []util.ChatMessage `json:"history"`
+	Cookies           string             `json:"cookies"`
+	ImageURL          string             `json:"imageUrl"`
+	NoSearch          bool               `json:"noSearch"`
+	UseGPT4Turbo      bool               `json:"gpt4turbo"`
+	UseClassic        bool               `json:"classic"`
+	ConversationStyle string             `json:"conversationStyle"`
+	Plugins           []string           `json:"plugins"`
+	Session           bool               `json:"session"`
+	SessionID         string             `json:"sessionId"`
+	EmitRaw           bool               `json:"raw"`
+	CitationStyle     string             `json:"citationStyle"`
 }
 
 // The `content` field can have different types
@@ -61,11 +73,12 @@ type OpenAIMessagesParseResult struct {
 
 // Most fields are omitted due to limitations of the Bing API
 type OpenAIChatCompletionRequest struct {
-	Model        string                            `json:"model"`
-	Messages     []OpenAIMessage                   `json:"messages"`
-	Stream       bool                              `json:"stream"`
-	ToolChoice   *interface{}                      `json:"tool_choice"`
-	Conversation sydney.CreateConversationResponse `json:"conversation"`
+	Model         string                            `json:"model"`
+	Messages      []OpenAIMessage                   `json:"messages"`
+	Stream        bool                              `json:"stream"`
+	ToolChoice    *interface{}                      `json:"tool_choice"`
+	Conversation  sydney.CreateConversationResponse `json:"conversation"`
+	CitationStyle string                            `json:"citation_style"`
 }
 
 type ChoiceDelta struct {
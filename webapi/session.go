@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sydneyqt/sydney"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionStore keeps multi-turn sessions of /chat/stream alive between requests.
+// Sessions that are not used within the ttl are removed.
+type SessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*storedSession
+}
+
+type storedSession struct {
+	session  *sydney.Session
+	account  *sydney.Account // nil unless picked from the account pool
+	lastUsed time.Time
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	store := &SessionStore{
+		ttl:      ttl,
+		sessions: map[string]*storedSession{},
+	}
+	go store.cleaner()
+	return store
+}
+
+// Create stores the session made with the account, which may be nil, and returns its id.
+func (o *SessionStore) Create(session *sydney.Session, account *sydney.Account) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	id := uuid.New().String()
+	o.sessions[id] = &storedSession{
+		session:  session,
+		account:  account,
+		lastUsed: time.Now(),
+	}
+	return id
+}
+
+// Get returns the session and the account it has been made with.
+func (o *SessionStore) Get(id string) (*sydney.Session, *sydney.Account, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stored, ok := o.sessions[id]
+	if !ok {
+		return nil, nil, false
+	}
+	stored.lastUsed = time.Now()
+	return stored.session, stored.account, true
+}
+
+func (o *SessionStore) Delete(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.sessions, id)
+}
+
+func (o *SessionStore) cleaner() {
+	for range time.Tick(time.Minute) {
+		o.mu.Lock()
+		for id, stored := range o.sessions {
+			if time.Since(stored.lastUsed) > o.ttl {
+				delete(o.sessions, id)
+			}
+		}
+		o.mu.Unlock()
+	}
+}
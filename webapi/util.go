@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"net/http"
 	"strings"
+	"sydneyqt/sydney"
 )
 
 func ParseCookies(cookiesStr string) map[string]string {
@@ -14,3 +17,22 @@ func ParseCookies(cookiesStr string) map[string]string {
 	}
 	return cookies
 }
+
+// ErrorStatusCode returns the HTTP status code of the error from Sydney.
+func ErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, sydney.ErrNoAccountAvailable), errors.Is(err, sydney.ErrNoAccountConfigured):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, sydney.ErrTurnLimitReached), errors.Is(err, sydney.ErrSessionBusy):
+		return http.StatusConflict
+	case errors.Is(err, sydney.ErrUnauthorized):
+		return http.StatusForbidden // not 401, which is for AUTH_TOKEN
+	case errors.Is(err, sydney.ErrThrottled), errors.Is(err, sydney.ErrCaptchaRequired):
+		return http.StatusTooManyRequests
+	case errors.Is(err, sydney.ErrContextTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, sydney.ErrConversationCreate):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sydneyqt/sydney"
 	"sydneyqt/util"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -24,10 +28,16 @@ func main() {
 		port = "8080"
 	}
 
-	proxy := os.Getenv("HTTPS_PROXY")
+	proxy := os.Getenv("PROXY_CONFIG")
+	if proxy == "" {
+		proxy = os.Getenv("HTTPS_PROXY")
+	}
 	if proxy == "" {
 		proxy = os.Getenv("HTTP_PROXY")
 	}
+	if _, err := util.ParseProxyConfig(proxy); err != nil {
+		log.Fatal("cannot parse the proxy: " + err.Error())
+	}
 
 	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
 	if allowedOrigins == "" {
@@ -49,6 +59,120 @@ func main() {
 
 	authToken := os.Getenv("AUTH_TOKEN")
 
+	var endpoints sydney.Endpoints
+	if endpointsStr := os.Getenv("BING_ENDPOINTS"); endpointsStr != "" {
+		if err := json.Unmarshal([]byte(endpointsStr), &endpoints); err != nil {
+			log.Fatal("cannot parse BING_ENDPOINTS: " + err.Error())
+		}
+	}
+
+	var captchaSolver sydney.CaptchaSolver
+	if captchaSolverStr := os.Getenv("CAPTCHA_SOLVER"); captchaSolverStr != "" {
+		var err error
+		captchaSolver, err = sydney.ParseCaptchaSolver(captchaSolverStr)
+		if err != nil {
+			log.Fatal("cannot parse CAPTCHA_SOLVER: " + err.Error())
+		}
+	}
+
+	var clientProfiles []sydney.ClientProfile
+	if clientProfilesFile := os.Getenv("CLIENT_PROFILES_FILE"); clientProfilesFile != "" {
+		var err error
+		clientProfiles, err = sydney.ReadClientProfiles(clientProfilesFile)
+		if err != nil {
+			log.Fatal("cannot read CLIENT_PROFILES_FILE: " + err.Error())
+		}
+	}
+
+	var accountPool *sydney.AccountPool
+	if accountsDir := os.Getenv("ACCOUNTS_DIR"); accountsDir != "" {
+		cooldown, err := time.ParseDuration(util.Ternary(os.Getenv("ACCOUNT_COOLDOWN") == "",
+			"10m", os.Getenv("ACCOUNT_COOLDOWN")))
+		if err != nil {
+			log.Fatal("cannot parse ACCOUNT_COOLDOWN: " + err.Error())
+		}
+		accountPool, err = sydney.NewAccountPool(sydney.AccountPoolOptions{
+			Strategy: sydney.AccountStrategy(os.Getenv("ACCOUNT_STRATEGY")),
+			Cooldown: cooldown,
+			Dir:      accountsDir,
+		})
+		if err != nil {
+			log.Fatal("cannot load accounts: " + err.Error())
+		}
+		slog.Info("ACCOUNTS_DIR set, default cookies will be ignored")
+	}
+
+	var conversationPool *sydney.ConversationPool
+	if poolSizeStr := os.Getenv("CONVERSATION_POOL_SIZE"); poolSizeStr != "" {
+		poolSize, err := strconv.Atoi(poolSizeStr)
+		if err != nil {
+			log.Fatal("cannot parse CONVERSATION_POOL_SIZE: " + err.Error())
+		}
+		var maxAge time.Duration
+		if maxAgeStr := os.Getenv("CONVERSATION_POOL_MAX_AGE"); maxAgeStr != "" {
+			maxAge, err = time.ParseDuration(maxAgeStr)
+			if err != nil {
+				log.Fatal("cannot parse CONVERSATION_POOL_MAX_AGE: " + err.Error())
+			}
+		}
+		conversationPool = sydney.NewConversationPool(sydney.ConversationPoolOptions{
+			Size:    poolSize,
+			MaxAge:  maxAge,
+			Connect: os.Getenv("CONVERSATION_POOL_CONNECT") != "",
+		})
+	}
+
+	newOptions := func(cookies map[string]string) sydney.Options {
+		return sydney.Options{
+			Cookies:              cookies,
+			Proxy:                proxy,
+			Endpoints:            endpoints,
+			CaptchaSolver:        captchaSolver,
+			ClientProfile:        os.Getenv("CLIENT_PROFILE"),
+			CustomClientProfiles: clientProfiles,
+		}
+	}
+	// the client of the default cookies is shared by all requests, which set their chat options
+	// with AskStreamOptions.ChatOptions
+	defaultOptions := newOptions(defaultCookies)
+	defaultOptions.ConversationPool = conversationPool
+	defaultSydney := sydney.NewSydney(defaultOptions)
+	if conversationPool != nil && accountPool == nil {
+		conversationPool.Warm(defaultSydney)
+	}
+	// newSydney returns a Sydney with the cookies provided by the client, or else an account
+	// picked from the pool if configured, or else the default cookies. The returned account
+	// is nil unless picked from the pool.
+	newSydney := func(cookiesStr string) (*sydney.Sydney, *sydney.Account, error) {
+		if cookiesStr != "" {
+			return sydney.NewSydney(newOptions(ParseCookies(cookiesStr))), nil, nil
+		}
+		if accountPool == nil {
+			return defaultSydney, nil, nil
+		}
+		account, err := accountPool.Pick()
+		if err != nil {
+			return nil, nil, err
+		}
+		options := accountPool.Options(account, newOptions(nil))
+		options.ConversationPool = conversationPool
+		return sydney.NewSydney(options), account, nil
+	}
+	// report records the result of a request to the account if it is picked from the pool.
+	report := func(account *sydney.Account, err error) {
+		if account != nil {
+			accountPool.Report(account, err)
+		}
+	}
+	track := func(ctx context.Context, account *sydney.Account, ch <-chan sydney.Message) <-chan sydney.Message {
+		if account == nil {
+			return ch
+		}
+		return accountPool.Track(ctx, account, ch)
+	}
+
+	sessionStore := NewSessionStore(30 * time.Minute)
+
 	// create router
 	r := chi.NewRouter()
 
@@ -97,13 +221,54 @@ func main() {
 		fmt.Fprint(w, "OK")
 	})
 
+	r.Get("/accounts", func(w http.ResponseWriter, r *http.Request) {
+		if accountPool == nil {
+			http.Error(w, "ACCOUNTS_DIR not set", http.StatusNotFound)
+			return
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(accountPool.Status())
+	})
+
+	r.Post("/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		var request DiagnosticsRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// run diagnostics outside the pools: check the account next in turn without taking it,
+		// and create conversations of its own
+		sydneyAPI := defaultSydney
+		if request.Cookies != "" {
+			sydneyAPI = sydney.NewSydney(newOptions(ParseCookies(request.Cookies)))
+		} else if accountPool != nil {
+			account, err := accountPool.Peek()
+			if err != nil {
+				http.Error(w, err.Error(), ErrorStatusCode(err))
+				return
+			}
+			sydneyAPI = sydney.NewSydney(accountPool.Options(account, newOptions(nil)))
+		}
+		diagnosticReport := sydneyAPI.RunDiagnostics(r.Context(), sydney.DiagnosticsOptions{})
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(diagnosticReport)
+	})
+
 	r.Post("/image/upload", func(w http.ResponseWriter, r *http.Request) {
 		// parse request
 		r.ParseMultipartForm(16 << 20)
 
-		cookiesStr := r.FormValue("cookies")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
-
 		file, _, err := r.FormFile("file")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -118,15 +283,16 @@ func main() {
 		}
 
 		// upload image
-		imgUrl, err := sydney.
-			NewSydney(sydney.Options{
-				Cookies: cookies,
-				Proxy:   proxy,
-			}).
-			UploadImage(bytes)
+		sydneyAPI, account, err := newSydney(r.FormValue("cookies"))
+		if err != nil {
+			http.Error(w, err.Error(), ErrorStatusCode(err))
+			return
+		}
+		imgUrl, err := sydneyAPI.UploadImage(r.Context(), bytes)
+		report(account, err)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), ErrorStatusCode(err))
 			return
 		}
 
@@ -146,19 +312,17 @@ func main() {
 			return
 		}
 
-		cookies := util.Ternary(request.Cookies == "", defaultCookies, ParseCookies(request.Cookies))
-
 		// create image
-		image, err := sydney.
-			NewSydney(sydney.Options{
-				Cookies:           cookies,
-				Proxy:             proxy,
-				ConversationStyle: "Creative",
-			}).
-			GenerateImage(request.Image)
+		sydneyAPI, account, err := newSydney(request.Cookies)
+		if err != nil {
+			http.Error(w, err.Error(), ErrorStatusCode(err))
+			return
+		}
+		image, err := sydneyAPI.GenerateImage(r.Context(), request.Image)
+		report(account, err)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), ErrorStatusCode(err))
 			return
 		}
 
@@ -180,30 +344,70 @@ func main() {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if !slices.Contains(sydney.CitationStyles, request.CitationStyle) {
+			http.Error(w, "unknown citation style: "+request.CitationStyle, http.StatusBadRequest)
+			return
+		}
 
-		cookies := util.Ternary(request.Cookies == "", defaultCookies, ParseCookies(request.Cookies))
-
-		sydneyAPI := sydney.NewSydney(sydney.Options{
-			Cookies:           cookies,
-			Proxy:             proxy,
-			ConversationStyle: request.ConversationStyle,
-			NoSearch:          request.NoSearch,
-			GPT4Turbo:         request.UseGPT4Turbo,
-			UseClassic:        request.UseClassic,
-			Plugins:           request.Plugins,
-		})
+		// continue or start a multi-turn session if requested; a continued session goes on
+		// with the account it has been started with
+		var session *sydney.Session
+		var account *sydney.Account
+		sessionID := request.SessionID
+		if sessionID != "" {
+			var ok bool
+			session, account, ok = sessionStore.Get(sessionID)
+			if !ok {
+				http.Error(w, "session not found: "+sessionID, http.StatusNotFound)
+				return
+			}
+		}
 
-		// stream chat
-		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+		askStreamOptions := sydney.AskStreamOptions{
 			StopCtx:        r.Context(),
 			Prompt:         request.Prompt,
 			WebpageContext: request.WebpageContext,
+			History:        request.History,
 			ImageURL:       request.ImageURL,
-		})
+			ChatOptions: &sydney.ChatOptions{
+				ConversationStyle: request.ConversationStyle,
+				NoSearch:          request.NoSearch,
+				GPT4Turbo:         request.UseGPT4Turbo,
+				UseClassic:        request.UseClassic,
+				Plugins:           request.Plugins,
+			},
+			EmitRaw:       request.EmitRaw,
+			CitationStyle: request.CitationStyle,
+		}
+
+		// stream chat
+		var messageCh <-chan sydney.Message
+		if session != nil {
+			messageCh, err = session.Ask(askStreamOptions)
+		} else {
+			var sydneyAPI *sydney.Sydney
+			sydneyAPI, account, err = newSydney(request.Cookies)
+			if err != nil {
+				http.Error(w, err.Error(), ErrorStatusCode(err))
+				return
+			}
+			if request.Session {
+				session = sydneyAPI.NewSession()
+				sessionID = sessionStore.Create(session, account)
+				messageCh, err = session.Ask(askStreamOptions)
+			} else {
+				messageCh, err = sydneyAPI.AskStream(askStreamOptions)
+			}
+		}
 		if err != nil {
-			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			report(account, err)
+			if sessionID != "" && !errors.Is(err, sydney.ErrSessionBusy) {
+				sessionStore.Delete(sessionID)
+			}
+			http.Error(w, "error creating conversation: "+err.Error(), ErrorStatusCode(err))
 			return
 		}
+		messageCh = track(r.Context(), account, messageCh)
 
 		// set headers
 		w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
@@ -211,6 +415,10 @@ func main() {
 		w.Header().Set("Connection", "keep-alive")
 
 		// write response
+		if sessionID != "" {
+			encoded, _ := json.Marshal(sessionID)
+			fmt.Fprintf(w, "event: session\ndata: %s\n\n", encoded)
+		}
 		for message := range messageCh {
 			encoded, _ := json.Marshal(message.Text)
 			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, encoded)
@@ -236,31 +444,39 @@ func main() {
 			return
 		}
 
-		cookiesStr := r.Header.Get("Cookie")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
-
 		conversationStyle := util.Ternary(
 			strings.HasPrefix(request.Model, "gpt-3.5-turbo"), "Balanced", "Creative")
 
-		sydneyAPI := sydney.NewSydney(sydney.Options{
-			Cookies:           cookies,
-			Proxy:             proxy,
-			ConversationStyle: conversationStyle,
-			Locale:            "en-US",
-			NoSearch:          request.ToolChoice == nil,
-			GPT4Turbo:         true,
-		})
+		if !slices.Contains(sydney.CitationStyles, request.CitationStyle) {
+			http.Error(w, "unknown citation style: "+request.CitationStyle, http.StatusBadRequest)
+			return
+		}
+
+		sydneyAPI, account, err := newSydney(r.Header.Get("Cookie"))
+		if err != nil {
+			http.Error(w, err.Error(), ErrorStatusCode(err))
+			return
+		}
 
 		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
 			StopCtx:        r.Context(),
 			Prompt:         parsedMessages.Prompt,
 			WebpageContext: parsedMessages.WebpageContext,
 			ImageURL:       parsedMessages.ImageURL,
+			ChatOptions: &sydney.ChatOptions{
+				ConversationStyle: conversationStyle,
+				Locale:            "en-US",
+				NoSearch:          request.ToolChoice == nil,
+				GPT4Turbo:         true,
+			},
+			CitationStyle: request.CitationStyle,
 		})
 		if err != nil {
-			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			report(account, err)
+			http.Error(w, "error creating conversation: "+err.Error(), ErrorStatusCode(err))
 			return
 		}
+		messageCh = track(r.Context(), account, messageCh)
 
 		// handle non-stream
 		if !request.Stream {
@@ -275,6 +491,9 @@ func main() {
 				switch message.Type {
 				case sydney.MessageTypeMessageText:
 					replyBuilder.WriteString(message.Text)
+				case sydney.MessageTypeAdaptiveCard:
+					replyBuilder.WriteString("\n\n")
+					replyBuilder.WriteString(message.Text)
 				case sydney.MessageTypeError:
 					errored = true
 					replyBuilder.WriteString("`Error: ")
@@ -306,6 +525,8 @@ func main() {
 			switch message.Type {
 			case sydney.MessageTypeMessageText:
 				delta = message.Text
+			case sydney.MessageTypeAdaptiveCard:
+				delta = "\n\n" + message.Text
 			case sydney.MessageTypeError:
 				errored = true
 				delta = fmt.Sprintf("`Error: %s`", message.Text)
@@ -341,15 +562,11 @@ func main() {
 			return
 		}
 
-		cookiesStr := r.Header.Get("Cookie")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
-
-		sydneyAPI := sydney.NewSydney(sydney.Options{
-			Cookies:           cookies,
-			Proxy:             proxy,
-			ConversationStyle: "Creative",
-			Locale:            "en-US",
-		})
+		sydneyAPI, account, err := newSydney(r.Header.Get("Cookie"))
+		if err != nil {
+			http.Error(w, err.Error(), ErrorStatusCode(err))
+			return
+		}
 
 		// ask stream
 		newContext, cancel := context.WithCancel(r.Context())
@@ -359,9 +576,14 @@ func main() {
 			StopCtx:        newContext,
 			Prompt:         "Create image for the description: " + request.Prompt,
 			WebpageContext: ImageGeneratorContext,
+			ChatOptions: &sydney.ChatOptions{
+				ConversationStyle: "Creative",
+				Locale:            "en-US",
+			},
 		})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			report(account, err)
+			http.Error(w, err.Error(), ErrorStatusCode(err))
 			return
 		}
 
@@ -369,10 +591,8 @@ func main() {
 
 		for message := range messageCh {
 			if message.Type == sydney.MessageTypeGenerativeImage {
-				err := json.Unmarshal([]byte(message.Text), &generativeImage)
-				if err == nil {
-					break
-				}
+				generativeImage = *message.Image
+				break
 			}
 		}
 		cancel()
@@ -383,9 +603,10 @@ func main() {
 		}
 
 		// create image
-		image, err := sydneyAPI.GenerateImage(generativeImage)
+		image, err := sydneyAPI.GenerateImage(r.Context(), generativeImage)
+		report(account, err)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), ErrorStatusCode(err))
 			return
 		}
 